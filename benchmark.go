@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// benchmarkResult is one model's run in --mode benchmark.
+type benchmarkResult struct {
+	Model        string  `json:"model"`
+	Message      string  `json:"message,omitempty"`
+	Error        string  `json:"error,omitempty"`
+	Skipped      bool    `json:"skipped,omitempty"`
+	WallMillis   int64   `json:"wall_ms"`
+	OutputTokens int     `json:"output_tokens,omitempty"`
+	TokensPerSec float64 `json:"tokens_per_sec,omitempty"`
+}
+
+func (c Config) benchmarkModels() []string {
+	if c.Models == "" {
+		return []string{c.Model}
+	}
+	var models []string
+	for _, m := range strings.Split(c.Models, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			models = append(models, m)
+		}
+	}
+	return models
+}
+
+// runBenchmark generates a commit message for the same diff with each of
+// --models, bypassing the cache and --fallback-model so every model is
+// measured on its own, and reports the message, wall time and generation
+// speed. A failing model is reported and the rest still run; a model whose
+// context window the diff does not fit is reported as skipped.
+func runBenchmark(ctx context.Context, cfg *Config, diff string) error {
+	var results []benchmarkResult
+	for _, model := range cfg.benchmarkModels() {
+		infof("Benchmarking %s...\n", model)
+		var resp OllamaResponse
+		runCfg := *cfg
+		runCfg.Model = model
+		runCfg.FallbackModel = ""
+		runCfg.NoCache = true
+		runCfg.lastResponse = &resp
+
+		if err := checkDiffSize(ctx, &runCfg, diff); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			results = append(results, benchmarkResult{Model: model, Error: err.Error(), Skipped: true})
+			continue
+		}
+
+		start := time.Now()
+		msg, err := generateCommitMessage(ctx, &runCfg, diff)
+		result := benchmarkResult{Model: model, Message: msg, WallMillis: time.Since(start).Milliseconds()}
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			result.Error = err.Error()
+		}
+		result.OutputTokens = resp.EvalCount
+		if resp.EvalDuration > 0 {
+			result.TokensPerSec = float64(resp.EvalCount) / time.Duration(resp.EvalDuration).Seconds()
+		}
+		results = append(results, result)
+	}
+
+	if cfg.Format == "json" {
+		writeJSON(results)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MODEL\tTIME\tTOKENS\tTOKENS/SEC\tMESSAGE")
+	for _, r := range results {
+		tokens, speed := "-", "-"
+		if r.OutputTokens > 0 {
+			tokens = fmt.Sprint(r.OutputTokens)
+			speed = fmt.Sprintf("%.1f", r.TokensPerSec)
+		}
+		message := firstLine(r.Message)
+		if r.Skipped {
+			message = "skipped: " + firstLine(r.Error)
+		} else if r.Error != "" {
+			message = "error: " + firstLine(r.Error)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Model, (time.Duration(r.WallMillis) * time.Millisecond).String(), tokens, speed, message)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The cache key is a hash of the full request body, so any change to the
+// diff, model, prompt or sampling options produces a new entry.
+func cacheKey(requestBody []byte) string {
+	sum := sha256.Sum256(requestBody)
+	return hex.EncodeToString(sum[:])
+}
+
+func cachePath(key string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "llamapusher", key), nil
+}
+
+// readCache returns the cached response for key if it is younger than ttl.
+func readCache(key string, ttl time.Duration) (string, bool) {
+	path, err := cachePath(key)
+	if err != nil {
+		return "", false
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) > ttl {
+		return "", false
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+func writeCache(key, response string) {
+	path, err := cachePath(key)
+	if err != nil {
+		debugf("cache disabled: %v", err)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		debugf("cache write failed: %v", err)
+		return
+	}
+	if err := os.WriteFile(path, []byte(response), 0o600); err != nil {
+		debugf("cache write failed: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCacheKey(t *testing.T) {
+	body := []byte(`{"model":"a","prompt":"p"}`)
+	if cacheKey(body) != cacheKey([]byte(`{"model":"a","prompt":"p"}`)) {
+		t.Error("the same request body gave two keys")
+	}
+	if cacheKey(body) == cacheKey([]byte(`{"model":"b","prompt":"p"}`)) {
+		t.Error("different request bodies gave the same key")
+	}
+	if got := len(cacheKey(body)); got != 64 {
+		t.Errorf("key is %d characters, want a sha256 hex digest", got)
+	}
+}
+
+// TestGenerationCache repeats a request with one thing changed at a time and
+// checks whether it reached the model or was served from the cache.
+func TestGenerationCache(t *testing.T) {
+	tests := []struct {
+		name       string
+		modify     func(cfg *Config, prompt *string)
+		wantCached bool
+	}{
+		{name: "identical request", modify: func(*Config, *string) {}, wantCached: true},
+		{name: "different diff", modify: func(_ *Config, prompt *string) { *prompt += "\n+one more line" }},
+		{name: "different model", modify: func(cfg *Config, _ *string) { cfg.Model = "other-model" }},
+		{name: "different system prompt", modify: func(cfg *Config, _ *string) { cfg.SystemPrompt = "Be brief." }},
+		{name: "different temperature", modify: func(cfg *Config, _ *string) { cfg.Temperature = 0.2 }},
+		{name: "expired", modify: func(cfg *Config, _ *string) { cfg.CacheTTL = time.Nanosecond }},
+		{name: "--no-cache", modify: func(cfg *Config, _ *string) { cfg.NoCache = true }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testRepo(t)
+			cfg, requests := ollamaStub(t, "feat: add login")
+			cfg.NoCache = false
+			prompt := getPromptForSingleCommit(sampleDiff, "", cfg.Language, "")
+			if _, err := generateWithModel(context.Background(), cfg, prompt); err != nil {
+				t.Fatal(err)
+			}
+
+			tt.modify(cfg, &prompt)
+			text, err := generateWithModel(context.Background(), cfg, prompt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if text != "feat: add login" {
+				t.Errorf("response %q, want %q", text, "feat: add login")
+			}
+			wantRequests := 2
+			if tt.wantCached {
+				wantRequests = 1
+			}
+			if got := len(requests()); got != wantRequests {
+				t.Errorf("%d requests reached the model, want %d", got, wantRequests)
+			}
+		})
+	}
+}
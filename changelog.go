@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	changelogEntryRe = regexp.MustCompile(`^(?:[-*]\s*)?(Added|Changed|Deprecated|Removed|Fixed|Security)\s*[:\-–]\s*(.+)$`)
+	unreleasedRe     = regexp.MustCompile(`(?i)^##\s*\[?unreleased\]?`)
+)
+
+func generateChangelogEntry(ctx context.Context, cfg *Config, diff string) error {
+	prompt := getPromptForChangelog(diff, cfg.Language, cfg.Instructions)
+
+	if !filterAPI(prompt, 1, cfg.FilterFee) {
+		return errFeeDeclined
+	}
+
+	changelogCfg := *cfg
+	changelogCfg.SystemPrompt = ""
+	text, err := sendMessage(ctx, &changelogCfg, prompt)
+	if err != nil {
+		return err
+	}
+
+	section, entry := parseChangelogEntry(text)
+	if entry == "" {
+		return errors.New("the model did not return a changelog entry")
+	}
+
+	path := changelogPath(cfg.ChangelogFile)
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	updated, changed := insertChangelogEntry(string(content), section, entry)
+	if !changed {
+		infof("%s already contains \"%s\" under %s, nothing to do\n", cfg.ChangelogFile, entry, section)
+		return nil
+	}
+
+	if err := os.WriteFile(path, []byte(updated), 0o644); err != nil {
+		return err
+	}
+	infof("Added to %s under Unreleased → %s:\n- %s\n", cfg.ChangelogFile, section, entry)
+	return nil
+}
+
+// changelogPath resolves a relative --changelog-file against the top of the
+// target repository's worktree, which is not necessarily the directory the
+// tool runs in (--repo, or a subdirectory).
+func changelogPath(file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	output, err := gitCommand("rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return file
+	}
+	return filepath.Join(strings.TrimSpace(string(output)), file)
+}
+
+func getPromptForChangelog(diff, language, instructions string) string {
+	return "From the following git diff write a single Keep a Changelog entry in " + language + " language. " +
+		"Reply with exactly one line in the form '<Added|Changed|Deprecated|Removed|Fixed|Security>: <description>' and nothing else. " +
+		userInstructions(instructions) +
+		"START OF GIT DIFF:\n" +
+		diff +
+		"\nEND OF GIT DIFF"
+}
+
+// parseChangelogEntry finds the first "Section: text" line in the model
+// output. Output without a recognised section is filed under Changed.
+func parseChangelogEntry(text string) (section, entry string) {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if m := changelogEntryRe.FindStringSubmatch(line); m != nil {
+			return m[1], strings.TrimSpace(m[2])
+		}
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "-*"))
+		if line != "" {
+			return "Changed", line
+		}
+	}
+	return "", ""
+}
+
+// insertChangelogEntry adds "- entry" to the given section of the Unreleased
+// block, creating the file skeleton, the Unreleased block or the section as
+// needed. It reports false when the identical entry is already present.
+func insertChangelogEntry(content, section, entry string) (string, bool) {
+	if strings.TrimSpace(content) == "" {
+		content = "# Changelog\n"
+	}
+	lines := strings.Split(strings.TrimRight(content, "\n"), "\n")
+
+	unreleased := -1
+	for i, line := range lines {
+		if unreleasedRe.MatchString(line) {
+			unreleased = i
+			break
+		}
+	}
+	if unreleased == -1 {
+		at := len(lines)
+		for i, line := range lines {
+			if strings.HasPrefix(line, "## ") {
+				at = i
+				break
+			}
+		}
+		insert := []string{"## [Unreleased]", ""}
+		if at > 0 && strings.TrimSpace(lines[at-1]) != "" {
+			insert = append([]string{""}, insert...)
+		}
+		lines = spliceLines(lines, at, insert...)
+		unreleased = at + len(insert) - 2
+	}
+
+	end := len(lines)
+	for i := unreleased + 1; i < len(lines); i++ {
+		if strings.HasPrefix(lines[i], "## ") {
+			end = i
+			break
+		}
+	}
+
+	bullet := "- " + entry
+	heading := -1
+	for i := unreleased + 1; i < end; i++ {
+		if strings.EqualFold(strings.TrimSpace(lines[i]), "### "+section) {
+			heading = i
+			break
+		}
+	}
+
+	if heading == -1 {
+		insertAt := end
+		for insertAt > unreleased+1 && strings.TrimSpace(lines[insertAt-1]) == "" {
+			insertAt--
+		}
+		newLines := []string{"", "### " + section, "", bullet}
+		if end < len(lines) && insertAt == end {
+			newLines = append(newLines, "")
+		}
+		return joinLines(spliceLines(lines, insertAt, newLines...)), true
+	}
+
+	last := heading
+	for i := heading + 1; i < end && !strings.HasPrefix(lines[i], "### "); i++ {
+		if strings.TrimSpace(lines[i]) == bullet {
+			return content, false
+		}
+		if strings.HasPrefix(strings.TrimSpace(lines[i]), "- ") {
+			last = i
+		}
+	}
+	if last == heading {
+		return joinLines(spliceLines(lines, heading+1, "", bullet)), true
+	}
+	return joinLines(spliceLines(lines, last+1, bullet)), true
+}
+
+func spliceLines(lines []string, at int, insert ...string) []string {
+	out := make([]string, 0, len(lines)+len(insert))
+	out = append(out, lines[:at]...)
+	out = append(out, insert...)
+	return append(out, lines[at:]...)
+}
+
+func joinLines(lines []string) string {
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n") + "\n"
+}
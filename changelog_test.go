@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestChangelogFileLocation runs changelog mode from the repository, a
+// subdirectory and outside it with --repo, and checks the entry lands in
+// the repository's changelog.
+func TestChangelogFileLocation(t *testing.T) {
+	absolute := filepath.Join(t.TempDir(), "CHANGES.md")
+	tests := []struct {
+		name string
+		cwd  string // relative to the repository, or outside it if "-"
+		args []string
+		want string // relative to the repository unless absolute
+	}{
+		{name: "repository", cwd: ".", want: "CHANGELOG.md"},
+		{name: "subdirectory", cwd: "pkg", want: "CHANGELOG.md"},
+		{name: "--repo", cwd: "-", want: "CHANGELOG.md"},
+		{name: "relative --changelog-file", cwd: "pkg", args: []string{"--changelog-file", "CHANGES.md"}, want: "CHANGES.md"},
+		{name: "absolute --changelog-file", cwd: "pkg", args: []string{"--changelog-file", absolute}, want: absolute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := testRepo(t)
+			stageFile(t, dir, "pkg/login.go", "package pkg\n")
+			cwd := filepath.Join(dir, tt.cwd)
+			args := append([]string{"--provider", "mock", "--mode", "changelog"}, tt.args...)
+			if tt.cwd == "-" {
+				cwd = t.TempDir()
+				args = append(args, "--repo", dir)
+			}
+
+			_, stderr, code := runCLI(t, cwd, "", []string{"LLAMAPUSHER_MOCK_RESPONSE=Added: login page"}, args...)
+			if code != 0 {
+				t.Fatalf("exit code %d, stderr:\n%s", code, stderr)
+			}
+			want := tt.want
+			if !filepath.IsAbs(want) {
+				want = filepath.Join(dir, want)
+			}
+			content, err := os.ReadFile(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(content), "- login page") {
+				t.Errorf("%s does not have the entry:\n%s", want, content)
+			}
+			if _, err := os.Stat(filepath.Join(cwd, "CHANGELOG.md")); tt.cwd != "." && err == nil {
+				t.Errorf("a CHANGELOG.md was created in %s", cwd)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// clipboardCommands lists the copy tools to try for the current platform,
+// in order of preference.
+func clipboardCommands() [][]string {
+	switch runtime.GOOS {
+	case "darwin":
+		return [][]string{{"pbcopy"}}
+	case "windows":
+		return [][]string{{"clip.exe"}}
+	}
+
+	var cmds [][]string
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		cmds = append(cmds, []string{"wl-copy"})
+	}
+	// clip.exe covers WSL.
+	return append(cmds, []string{"xclip", "-selection", "clipboard"}, []string{"xsel", "--clipboard", "--input"}, []string{"clip.exe"})
+}
+
+func copyToClipboard(text string) error {
+	for _, args := range clipboardCommands() {
+		if _, err := exec.LookPath(args[0]); err != nil {
+			continue
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		if err := cmd.Run(); err != nil {
+			return err
+		}
+		debugf("copied the message with %s", args[0])
+		return nil
+	}
+	return errors.New("no clipboard tool found (install pbcopy, wl-copy, xclip or xsel)")
+}
+
+// copyMessage copies msg for --clipboard. A missing or failing clipboard
+// tool only warns; it never stops the commit.
+func copyMessage(cfg *Config, msg string) {
+	if !cfg.Clipboard {
+		return
+	}
+	if err := copyToClipboard(msg); err != nil {
+		warnf("could not copy the message to the clipboard: %v", err)
+		return
+	}
+	infof("Copied the commit message to the clipboard 📋\n")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// useColor is decided once in main: on for a terminal stdout unless
+// --no-color is given or NO_COLOR is set to anything (https://no-color.org).
+var useColor bool
+
+func colorEnabled(noColor bool) bool {
+	if noColor {
+		return false
+	}
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	return isTerminal(os.Stdout)
+}
+
+func colorize(code, s string) string {
+	if !useColor || s == "" {
+		return s
+	}
+	return "\033[" + code + "m" + s + "\033[0m"
+}
+
+func dim(s string) string   { return colorize("2", s) }
+func red(s string) string   { return colorize("31", s) }
+func green(s string) string { return colorize("32", s) }
+func cyan(s string) string  { return colorize("36", s) }
+
+// highlightMessage shows a commit message with its conventional type in
+// cyan and the rest in green. Only the display is coloured; the message
+// that is committed is never touched.
+func highlightMessage(msg string) string {
+	if !useColor {
+		return msg
+	}
+
+	line, rest, _ := strings.Cut(msg, "\n")
+	// A prefixed gitmoji sits in front of the type.
+	lead := ""
+	if emoji, header, ok := strings.Cut(line, " "); ok && !conventionalHeaderRe.MatchString(line) && conventionalHeaderRe.MatchString(header) {
+		lead, line = emoji+" ", header
+	}
+
+	if m := conventionalHeaderRe.FindStringSubmatchIndex(line); m != nil {
+		line = lead + cyan(line[:m[3]]) + green(line[m[3]:])
+	} else {
+		line = green(lead + line)
+	}
+	if rest != "" {
+		line += "\n" + green(rest)
+	}
+	return line
+}
+
+// colorDiffLine colours a line of the trimmed diff the way git does.
+func colorDiffLine(line string) string {
+	switch {
+	case strings.HasPrefix(line, "+++ "), strings.HasPrefix(line, "--- "):
+		return colorize("1", line)
+	case strings.HasPrefix(line, "+"):
+		return green(line)
+	case strings.HasPrefix(line, "-"):
+		return red(line)
+	case strings.HasPrefix(line, "@@"):
+		return cyan(line)
+	}
+	return line
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// runCommitCommand commits msg with --commit-command instead of git commit.
+// The command runs with sh -c in the repository, gets the message on stdin
+// and in the file named by $LLAMAPUSHER_MESSAGE_FILE, e.g.
+// "git commit -F -" or "jj describe --stdin && jj new". It has to move
+// HEAD; a command that exits 0 without committing is an error.
+func runCommitCommand(cfg *Config, msg string) ([]byte, error) {
+	file, err := os.CreateTemp("", "llamapusher-msg-*.txt")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(msg + "\n"); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+
+	before, _ := revParseCommit("HEAD")
+	cmd := exec.Command("sh", "-c", cfg.CommitCommand)
+	cmd.Dir = repoPath
+	cmd.Stdin = strings.NewReader(msg + "\n")
+	cmd.Env = append(os.Environ(), "LLAMAPUSHER_MESSAGE_FILE="+file.Name())
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return output, err
+	}
+	if after, _ := revParseCommit("HEAD"); after == before {
+		return output, errors.New("it exited successfully but HEAD did not move, so no commit was made")
+	}
+	return output, nil
+}
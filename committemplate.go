@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// repoCommitTemplate finds the repository's commit scaffolding: the file
+// named by commit.template, or a .gitmessage at the top of the worktree.
+// It returns "" when there is none.
+func repoCommitTemplate() (path, content string) {
+	if output, err := gitCommand("config", "--path", "--get", "commit.template").Output(); err == nil {
+		path = strings.TrimSpace(string(output))
+	}
+	if path == "" {
+		output, err := gitCommand("rev-parse", "--show-toplevel").Output()
+		if err != nil {
+			return "", ""
+		}
+		path = filepath.Join(strings.TrimSpace(string(output)), ".gitmessage")
+	} else if !filepath.IsAbs(path) {
+		// git resolves a relative commit.template against the worktree root.
+		if output, err := gitCommand("rev-parse", "--show-toplevel").Output(); err == nil {
+			path = filepath.Join(strings.TrimSpace(string(output)), path)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", ""
+	}
+	return path, string(data)
+}
+
+// applyCommitTemplate uses the repository's commit template when --template
+// is not given. A template with a {COMMIT_MESSAGE} placeholder becomes the
+// --template; any other template is scaffolding, so it is shown to the model
+// as the structure to fill in.
+func applyCommitTemplate(cfg *Config) {
+	path, content := repoCommitTemplate()
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+
+	if strings.Contains(content, "{COMMIT_MESSAGE}") {
+		debugf("using %s as the template", path)
+		cfg.Template = stripCommentLines(content)
+		return
+	}
+
+	if cfg.FirstLineOnly {
+		debugf("not following %s: --first-line-only keeps only the subject", path)
+		return
+	}
+	debugf("following the structure of %s", path)
+	cfg.followsTemplate = true
+	cfg.SystemPrompt = strings.TrimSpace(cfg.SystemPrompt + " Follow the structure of this repository's commit template, " +
+		"filling in every part and leaving out the lines starting with #:\n" + strings.TrimSpace(content))
+}
+
+// stripCommentLines drops "#" lines the way git's default cleanup does.
+func stripCommentLines(text string) string {
+	var kept []string
+	for _, line := range strings.Split(text, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			kept = append(kept, line)
+		}
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// envPrefix starts the environment variable that can set each flag:
+// --max-output-tokens is LLAMAPUSHER_MAX_OUTPUT_TOKENS.
+const envPrefix = "LLAMAPUSHER_"
+
+const envHelp = `
+Environment:
+  Every flag can also be set with LLAMAPUSHER_<FLAG>, the flag name in
+  upper case with "-" replaced by "_" (e.g. LLAMAPUSHER_MODEL=llama3,
+  LLAMAPUSHER_NO_EMOJI=true). Empty variables are ignored.
+
+  Flags can also be set per repository, or in ~/.gitconfig, with git
+  config llamapusher.<flag>, e.g. git config llamapusher.model mistral or
+  git config llamapusher.ollamaUrl http://gpu:11434/api/generate.
+
+  The command line wins over the environment, the environment over git
+  config, git config over a --profile, and a profile over the rest of the
+  config file.
+`
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// explicitFlags records the flags given on the command line, in the
+// environment or in git config, which all beat the config file, its
+// per-type settings included.
+var explicitFlags = map[string]bool{}
+
+// recordExplicitFlags fills explicitFlags. It runs once the environment and
+// git config have been applied, before the config file is.
+func recordExplicitFlags() {
+	flag.Visit(func(f *flag.Flag) {
+		explicitFlags[f.Name] = true
+	})
+}
+
+// applyEnvironment sets every flag not given on the command line from its
+// LLAMAPUSHER_* variable. It runs before the config file is applied, which
+// then treats those flags as already set.
+func applyEnvironment() error {
+	onCommandLine := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		onCommandLine[f.Name] = true
+	})
+
+	var errs []error
+	flag.VisitAll(func(f *flag.Flag) {
+		if onCommandLine[f.Name] {
+			return
+		}
+		value := os.Getenv(envName(f.Name))
+		if value == "" {
+			return
+		}
+		if err := flag.Set(f.Name, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", envName(f.Name), err))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// gitConfigSection holds the llamapusher.* git config keys.
+const gitConfigSection = "llamapusher."
+
+// applyGitConfig sets every flag not given on the command line or in the
+// environment from its llamapusher.* git config key, with git's usual
+// local over global precedence. Git lower-cases key names, so they are
+// matched against the flag names without dashes: llamapusher.ollamaUrl is
+// --ollama-url. A repeated key sets a repeatable flag more than once.
+func applyGitConfig() error {
+	output, err := gitCommand("config", "--get-regexp", `^`+strings.ReplaceAll(gitConfigSection, ".", `\.`)).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() != 1 {
+			return fmt.Errorf("git config: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		// No llamapusher keys, or no git at all, which main reports later.
+		return nil
+	}
+
+	flags := map[string]*flag.Flag{}
+	flag.VisitAll(func(f *flag.Flag) {
+		flags[strings.ReplaceAll(f.Name, "-", "")] = f
+	})
+	alreadySet := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		alreadySet[f.Name] = true
+	})
+
+	var errs []error
+	setHere := map[string]bool{}
+	for _, line := range splitLines(string(output)) {
+		key, value, hasValue := strings.Cut(line, " ")
+		if !hasValue {
+			value = "true" // [llamapusher] emoji, with no "= value"
+		}
+		name := strings.TrimPrefix(key, gitConfigSection)
+		f, ok := flags[strings.ReplaceAll(name, "-", "")]
+		if !ok || f.Name == "config" {
+			errs = append(errs, fmt.Errorf("git config %s: unknown option %q", key, name))
+			continue
+		}
+		if alreadySet[f.Name] && !setHere[f.Name] {
+			continue
+		}
+		setHere[f.Name] = true
+		if err := flag.Set(f.Name, value); err != nil {
+			errs = append(errs, fmt.Errorf("git config %s: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// defaultConfigPath is where the config file is looked for when --config is
+// not given, e.g. ~/.config/llamapusher/config.json on Linux.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "llamapusher", "config.json")
+}
+
+// configFile is a JSON object keyed by flag name, e.g.
+// {"emoji": false, "model": "llama3", "stop": ["\n\n", "---"]}, with
+// optional named presets under "profiles":
+// {"profiles": {"fast": {"model": "tinydolphin", "temperature": 0.2}}},
+// and sampling settings by commit type under "types":
+// {"types": {"docs": {"temperature": 0}, "feat": {"temperature": 0.9}}}.
+type configFile struct {
+	path     string
+	values   map[string]any
+	profiles map[string]map[string]any
+	types    map[string]map[string]any
+}
+
+// loadConfigFile reads path. A missing file is only an error if it was
+// asked for explicitly; otherwise it behaves as an empty one.
+func loadConfigFile(path string, explicit bool) (*configFile, error) {
+	cf := &configFile{path: path}
+	if path == "" {
+		return cf, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !explicit && errors.Is(err, fs.ErrNotExist) {
+			return cf, nil
+		}
+		return nil, err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	if profiles, ok := raw["profiles"]; ok {
+		if err := json.Unmarshal(profiles, &cf.profiles); err != nil {
+			return nil, fmt.Errorf("config file %s: profiles: %w", path, err)
+		}
+		delete(raw, "profiles")
+	}
+	if types, ok := raw["types"]; ok {
+		if err := json.Unmarshal(types, &cf.types); err != nil {
+			return nil, fmt.Errorf("config file %s: types: %w", path, err)
+		}
+		for commitType, values := range cf.types {
+			for name := range values {
+				if samplingFlags(&Config{}).Lookup(name) == nil {
+					return nil, fmt.Errorf("config file %s: types: %s: %q is not a sampling option (expected one of %s)", path, commitType, name, strings.Join(samplingFlagNames(), ", "))
+				}
+			}
+		}
+		delete(raw, "types")
+	}
+	cf.values = map[string]any{}
+	for name, value := range raw {
+		var v any
+		if err := json.Unmarshal(value, &v); err != nil {
+			return nil, fmt.Errorf("config file %s: %s: %w", path, name, err)
+		}
+		cf.values[name] = v
+	}
+	return cf, nil
+}
+
+// apply sets every flag not already given on the command line, in the
+// environment or in git config: first from the profile (--profile, or
+// "profile" in the file), then from the file's top-level values, so the
+// precedence is command line, environment, git config, profile, config
+// file, built-in default.
+func (cf *configFile) apply(profile string) error {
+	alreadySet := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		alreadySet[f.Name] = true
+	})
+
+	if !alreadySet["profile"] {
+		if name, ok := cf.values["profile"].(string); ok {
+			profile = name
+		}
+	}
+	if profile != "" {
+		values, ok := cf.profiles[profile]
+		if !ok {
+			return fmt.Errorf("unknown --profile %q (available: %s)", profile, strings.Join(cf.profileNames(), ", "))
+		}
+		if err := cf.setFlags(values, alreadySet, "profile "+profile+": "); err != nil {
+			return err
+		}
+		debugf("applied profile %s", profile)
+	}
+
+	if err := cf.setFlags(cf.values, alreadySet, ""); err != nil {
+		return err
+	}
+	if cf.values != nil {
+		debugf("applied config file %s", cf.path)
+	}
+	return nil
+}
+
+func (cf *configFile) setFlags(values map[string]any, alreadySet map[string]bool, context string) error {
+	for name, value := range values {
+		if flag.Lookup(name) == nil || name == "config" || (context != "" && name == "profile") {
+			return fmt.Errorf("config file %s: %sunknown option %q", cf.path, context, name)
+		}
+		if alreadySet[name] {
+			continue
+		}
+		alreadySet[name] = true
+
+		items, ok := value.([]any)
+		if !ok {
+			items = []any{value}
+		}
+		for _, item := range items {
+			if err := flag.Set(name, configValueString(item)); err != nil {
+				return fmt.Errorf("config file %s: %s%s: %w", cf.path, context, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (cf *configFile) profileNames() []string {
+	names := make([]string, 0, len(cf.profiles))
+	for name := range cf.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// printProfiles implements --list-profiles.
+func (cf *configFile) printProfiles() {
+	if len(cf.profiles) == 0 {
+		fmt.Printf("No profiles in %s\n", cf.path)
+		return
+	}
+	for _, name := range cf.profileNames() {
+		values := cf.profiles[name]
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var settings []string
+		for _, key := range keys {
+			items, ok := values[key].([]any)
+			if !ok {
+				items = []any{values[key]}
+			}
+			for _, item := range items {
+				settings = append(settings, fmt.Sprintf("--%s=%s", key, configValueString(item)))
+			}
+		}
+		fmt.Printf("%s: %s\n", name, strings.Join(settings, " "))
+	}
+}
+
+func configValueString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// samplingFlags binds the options "types" in the config file may set to
+// cfg, so a per-type value can be parsed into any copy of the config.
+func samplingFlags(cfg *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet("types", flag.ContinueOnError)
+	fs.Float64Var(&cfg.Temperature, "temperature", cfg.Temperature, "")
+	fs.Float64Var(&cfg.TopP, "top-p", cfg.TopP, "")
+	fs.Float64Var(&cfg.RepetitionPenalty, "repetition-penalty", cfg.RepetitionPenalty, "")
+	fs.Var(&cfg.TopK, "top-k", "")
+	fs.Var(&cfg.MinP, "min-p", "")
+	fs.Var(&cfg.Mirostat, "mirostat", "")
+	fs.Var(&cfg.MirostatTau, "mirostat-tau", "")
+	fs.Var(&cfg.MirostatEta, "mirostat-eta", "")
+	fs.IntVar(&cfg.Seed, "seed", cfg.Seed, "")
+	return fs
+}
+
+func samplingFlagNames() []string {
+	var names []string
+	samplingFlags(&Config{}).VisitAll(func(f *flag.Flag) {
+		names = append(names, f.Name)
+	})
+	return names
+}
+
+// applyTypeSampling applies the config file's "types" settings for the
+// commit type, once it is known from --commit-type or --auto-type. A scope
+// is ignored, so "feat(api)" uses the "feat" entry. Options given on the
+// command line, in the environment or in git config win; everything else
+// falls back to the global values.
+func applyTypeSampling(cfg *Config) error {
+	commitType := strings.SplitN(cfg.CommitType, "(", 2)[0]
+	if values, ok := cfg.typeSampling[commitType]; ok {
+		fs := samplingFlags(cfg)
+		for name, value := range values {
+			if explicitFlags[name] {
+				continue
+			}
+			if err := fs.Set(name, configValueString(value)); err != nil {
+				return fmt.Errorf("config file: types: %s: %s: %w", commitType, name, err)
+			}
+		}
+	}
+	if commitType != "" {
+		debugf("sampling for %s commits: temperature %v, top-p %v, repetition-penalty %v", commitType, cfg.Temperature, cfg.TopP, cfg.RepetitionPenalty)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSettingPrecedence sets --model at several levels at once and checks
+// which one wins: the command line, then the environment, then git config
+// (the repository's over the global one), then the profile, then the rest
+// of the config file.
+func TestSettingPrecedence(t *testing.T) {
+	tests := []struct {
+		name                                          string
+		flag, env, gitLocal, gitGlobal, profile, file string
+		want                                          string
+	}{
+		{name: "built-in default", want: "tinydolphin:1.1b-v2.8-q5_K_M"},
+		{name: "config file", file: "file-model", want: "file-model"},
+		{name: "profile over config file", profile: "profile-model", file: "file-model", want: "profile-model"},
+		{name: "git config over profile", gitGlobal: "global-model", profile: "profile-model", file: "file-model", want: "global-model"},
+		{name: "repository over global git config", gitLocal: "local-model", gitGlobal: "global-model", want: "local-model"},
+		{name: "environment over git config", env: "env-model", gitLocal: "local-model", profile: "profile-model", want: "env-model"},
+		{name: "command line over everything", flag: "flag-model", env: "env-model", gitLocal: "local-model", gitGlobal: "global-model", profile: "profile-model", file: "file-model", want: "flag-model"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := testRepo(t)
+			stageFile(t, dir, "main.go", "package main\n")
+
+			config := `{"profile": "test", "profiles": {"test": {}}}`
+			switch {
+			case tt.profile != "" && tt.file != "":
+				config = `{"profile": "test", "profiles": {"test": {"model": "` + tt.profile + `"}}, "model": "` + tt.file + `"}`
+			case tt.profile != "":
+				config = `{"profile": "test", "profiles": {"test": {"model": "` + tt.profile + `"}}}`
+			case tt.file != "":
+				config = `{"model": "` + tt.file + `"}`
+			}
+			configPath := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.gitGlobal != "" {
+				git(t, "config", "--global", "llamapusher.model", tt.gitGlobal)
+			}
+			if tt.gitLocal != "" {
+				git(t, "config", "llamapusher.model", tt.gitLocal)
+			}
+			var env []string
+			if tt.env != "" {
+				env = append(env, "LLAMAPUSHER_MODEL="+tt.env)
+			}
+			args := []string{"--provider", "mock", "--message-only", "--config", configPath}
+			if tt.flag != "" {
+				args = append(args, "--model", tt.flag)
+			}
+
+			_, stderr, code := runCLI(t, dir, "", env, args...)
+			if code != 0 {
+				t.Fatalf("exit code %d, stderr:\n%s", code, stderr)
+			}
+			if want := "Model: " + tt.want + "\n"; !strings.Contains(stderr, want) {
+				t.Errorf("want %q, got:\n%s", want, stderr)
+			}
+		})
+	}
+}
+
+func TestGitConfigKeys(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     [][2]string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{name: "boolean", config: [][2]string{{"llamapusher.emoji", "false"}}, wantStdout: "chore: update main.go\n"},
+		{name: "camel case name", config: [][2]string{{"llamapusher.commitType", "feat"}}, wantStdout: "✨ feat: update main.go\n"},
+		{name: "dashed name", config: [][2]string{{"llamapusher.commit-type", "fix"}}, wantStdout: "🚑 fix: update main.go\n"},
+		{name: "unknown key", config: [][2]string{{"llamapusher.bogus", "1"}}, wantCode: exitError, wantStderr: `git config llamapusher.bogus: unknown option "bogus"`},
+		{name: "invalid value", config: [][2]string{{"llamapusher.maxRetries", "many"}}, wantCode: exitError, wantStderr: "git config llamapusher.maxretries"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := testRepo(t)
+			stageFile(t, dir, "main.go", "package main\n")
+			for _, kv := range tt.config {
+				git(t, "config", kv[0], kv[1])
+			}
+
+			stdout, stderr, code := runCLI(t, dir, "", nil, "--provider", "mock", "--message-only")
+			if code != tt.wantCode {
+				t.Fatalf("exit code %d, want %d; stderr:\n%s", code, tt.wantCode, stderr)
+			}
+			if tt.wantStdout != "" && stdout != tt.wantStdout {
+				t.Errorf("stdout %q, want %q", stdout, tt.wantStdout)
+			}
+			if !strings.Contains(stderr, tt.wantStderr) {
+				t.Errorf("stderr does not mention %q:\n%s", tt.wantStderr, stderr)
+			}
+		})
+	}
+}
+
+// TestTypeSamplingPrecedence checks that a config file "types" entry only
+// overrides the config file's own values, not the command line, the
+// environment or git config.
+func TestTypeSamplingPrecedence(t *testing.T) {
+	tests := []struct {
+		name      string
+		flag, env string
+		gitConfig string
+		want      string
+	}{
+		{name: "types entry", want: "0.2"},
+		{name: "command line", flag: "0.9", want: "0.9"},
+		{name: "environment", env: "0.7", want: "0.7"},
+		{name: "git config", gitConfig: "0.6", want: "0.6"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := testRepo(t)
+			stageFile(t, dir, "main.go", "package main\n")
+			configPath := filepath.Join(t.TempDir(), "config.json")
+			config := `{"temperature": 0.5, "types": {"feat": {"temperature": 0.2}}}`
+			if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.gitConfig != "" {
+				git(t, "config", "llamapusher.temperature", tt.gitConfig)
+			}
+			var env []string
+			if tt.env != "" {
+				env = append(env, "LLAMAPUSHER_TEMPERATURE="+tt.env)
+			}
+			args := []string{"--provider", "mock", "--message-only", "--log-level", "debug", "--commit-type", "feat", "--config", configPath}
+			if tt.flag != "" {
+				args = append(args, "--temperature", tt.flag)
+			}
+
+			_, stderr, code := runCLI(t, dir, "", env, args...)
+			if code != 0 {
+				t.Fatalf("exit code %d, stderr:\n%s", code, stderr)
+			}
+			if want := "sampling for feat commits: temperature " + tt.want + ","; !strings.Contains(stderr, want) {
+				t.Errorf("want %q, got:\n%s", want, stderr)
+			}
+		})
+	}
+}
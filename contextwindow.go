@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// promptOverheadTokens is room left in the context window for the prompt's
+// instructions and the generated message, on top of the system prompt.
+const promptOverheadTokens = 300
+
+// showURL turns the generate endpoint into the matching /api/show one.
+func showURL(generateURL string) string {
+	if base, ok := strings.CutSuffix(generateURL, "/api/generate"); ok {
+		return base + "/api/show"
+	}
+	return strings.TrimSuffix(generateURL, "/") + "/../show"
+}
+
+type ollamaShowResponse struct {
+	Parameters string         `json:"parameters"`
+	Template   *string        `json:"template"` // nil when the server leaves it out
+	ModelInfo  map[string]any `json:"model_info"`
+}
+
+// shownModels keeps each /api/show answer for the rest of the run, as
+// several checks look at the same model.
+var shownModels sync.Map
+
+// showModel asks Ollama for the details of cfg.Model.
+func showModel(ctx context.Context, cfg *Config) (*ollamaShowResponse, error) {
+	key := cfg.currentHost() + " " + cfg.Model
+	if show, ok := shownModels.Load(key); ok {
+		return show.(*ollamaShowResponse), nil
+	}
+
+	body, err := json.Marshal(map[string]string{"model": cfg.Model})
+	if err != nil {
+		return nil, err
+	}
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, showURL(cfg.currentHost()), bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if cfg.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.AuthToken)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, &httpStatusError{StatusCode: resp.StatusCode}
+	}
+
+	var show ollamaShowResponse
+	if err := json.NewDecoder(resp.Body).Decode(&show); err != nil {
+		return nil, err
+	}
+	shownModels.Store(key, &show)
+	return &show, nil
+}
+
+// modelContextWindow reads the model's details. window is the num_ctx the
+// model is configured to run with (0 if it uses the server default);
+// maxWindow is the longest context the model supports.
+func modelContextWindow(ctx context.Context, cfg *Config) (window, maxWindow int, err error) {
+	show, err := showModel(ctx, cfg)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, line := range strings.Split(show.Parameters, "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "num_ctx" {
+			window, _ = strconv.Atoi(fields[1])
+		}
+	}
+	for key, value := range show.ModelInfo {
+		if n, ok := value.(float64); ok && strings.HasSuffix(key, ".context_length") {
+			maxWindow = int(n)
+		}
+	}
+	return window, maxWindow, nil
+}
+
+// defaultMaxInputTokens caps the diff when neither --max-input-tokens nor
+// the context window is known.
+const defaultMaxInputTokens = 2048
+
+// checkDiffSize rejects a diff above --max-input-tokens or one that cannot
+// fit in the context window the request will run with, leaving room for the
+// system prompt, instructions and answer. Only the diff is counted, since
+// that is the part that varies. The window is --context-size, else the
+// model's own num_ctx.
+func checkDiffSize(ctx context.Context, cfg *Config, diff string) error {
+	diffTokens := estimateTokens(diff)
+	debugf("diff tokens (estimated): %d", diffTokens)
+
+	if cfg.MaxInputTokens > 0 && diffTokens > cfg.MaxInputTokens {
+		return fmt.Errorf("%w: ~%d tokens, max %d allowed by --max-input-tokens", ErrMessageTooLarge, diffTokens, cfg.MaxInputTokens)
+	}
+
+	window, maxWindow := cfg.ContextSize, 0
+	if window == 0 && cfg.Provider == "ollama" {
+		var err error
+		if window, maxWindow, err = modelContextWindow(ctx, cfg); err != nil {
+			debugf("could not read the context window of %s: %v", cfg.Model, err)
+		}
+	}
+
+	if window == 0 {
+		if cfg.MaxInputTokens == 0 && diffTokens > defaultMaxInputTokens {
+			return fmt.Errorf("%w: ~%d tokens, max %d allowed (set --context-size or --max-input-tokens to allow more)", ErrMessageTooLarge, diffTokens, defaultMaxInputTokens)
+		}
+		return nil
+	}
+
+	limit := window - estimateTokens(cfg.SystemPrompt) - promptOverheadTokens
+	debugf("context window %d, room for ~%d diff tokens", window, limit)
+	if diffTokens <= limit {
+		return nil
+	}
+	if maxWindow > window {
+		return fmt.Errorf("%w: ~%d tokens does not fit the %d-token context window (the model supports up to %d; raise --context-size)", ErrMessageTooLarge, diffTokens, window, maxWindow)
+	}
+	return fmt.Errorf("%w: ~%d tokens does not fit the %d-token context window", ErrMessageTooLarge, diffTokens, window)
+}
+
+// baseModelTagRe matches the tags Ollama's library uses for models without
+// instruction tuning, e.g. llama2:7b-text or mistral:7b-text-q4_0.
+var baseModelTagRe = regexp.MustCompile(`(?i)(^|[-_:.])(text|base|pretrain(ed)?)([-_:.]|$)`)
+
+// warnIfBaseModel warns when the model looks like a base (completion)
+// model, which continues the prompt instead of answering it and writes poor
+// commit messages: its tag says so, or it has no chat template. It is only
+// a guess, so it never stops the run, and --quiet hides it.
+func warnIfBaseModel(ctx context.Context, cfg *Config) {
+	if cfg.Provider != "ollama" {
+		return
+	}
+	reason := ""
+	if _, tag, _ := strings.Cut(cfg.Model, ":"); baseModelTagRe.MatchString(tag) {
+		reason = "its tag marks a base model"
+	} else if show, err := showModel(ctx, cfg); err != nil {
+		debugf("could not check whether %s is an instruct model: %v", cfg.Model, err)
+		return
+	} else if show.Template != nil {
+		// Ollama's default when a Modelfile has no TEMPLATE.
+		if template := strings.Join(strings.Fields(*show.Template), ""); template == "" || template == "{{.Prompt}}" {
+			reason = "it has no chat template"
+		}
+	}
+	if reason != "" {
+		warnf("%s looks like a base model rather than an instruct or chat one (%s); expect poor commit messages and consider an instruct variant", cfg.Model, reason)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// errCostLimit is returned instead of sending a request that would pass
+// --max-cost.
+var errCostLimit = fmt.Errorf("%w: --max-cost reached", ErrAborted)
+
+// spend is the run's request budget state, shared by --parallel requests.
+var spend struct {
+	sync.Mutex
+	cost float64
+	last time.Time
+}
+
+// estimateFee is the rough price of a request, as shown by --filter-fee.
+func estimateFee(prompt string, numCompletion int) float64 {
+	return float64(estimateTokens(prompt))/1000*0.02 + 0.001*float64(numCompletion)
+}
+
+// guardRequest runs before every request that is not served from the cache.
+// It waits out --min-request-interval since the last one, and refuses the
+// request once the estimated spend of the run would pass --max-cost, so a
+// regenerate loop against a paid endpoint cannot run away. Both are off by
+// default.
+func guardRequest(ctx context.Context, cfg *Config, prompt string) error {
+	spend.Lock()
+	defer spend.Unlock()
+
+	if cfg.MinRequestInterval > 0 && !spend.last.IsZero() {
+		if wait := cfg.MinRequestInterval - time.Since(spend.last); wait > 0 {
+			debugf("waiting %s for --min-request-interval", wait.Round(time.Millisecond))
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	fee := estimateFee(prompt, 1)
+	if cfg.MaxCost > 0 && spend.cost+fee > cfg.MaxCost {
+		return fmt.Errorf("%w: the next request (~$%.3f) would take this run past $%g (~$%.3f spent so far)", errCostLimit, fee, cfg.MaxCost, spend.cost)
+	}
+	spend.cost += fee
+	spend.last = time.Now()
+	debugf("estimated spend this run: ~$%.3f", spend.cost)
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resetSpend clears the run's request budget before and after a test.
+func resetSpend(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		spend.Lock()
+		spend.cost, spend.last = 0, time.Time{}
+		spend.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestEstimateFee(t *testing.T) {
+	tests := []struct {
+		prompt        string
+		numCompletion int
+		want          float64
+	}{
+		{"", 1, 0.001},
+		{"", 3, 0.003},
+		{strings.Repeat("word ", 1000), 1, 0.021},
+		{strings.Repeat("word ", 500), 2, 0.012},
+	}
+	for _, tt := range tests {
+		if got := estimateFee(tt.prompt, tt.numCompletion); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("estimateFee(%d words, %d) = %g, want %g", len(strings.Fields(tt.prompt)), tt.numCompletion, got, tt.want)
+		}
+	}
+}
+
+// TestGuardRequestMaxCost sends requests of ~$0.021 each against a budget.
+func TestGuardRequestMaxCost(t *testing.T) {
+	prompt := strings.Repeat("word ", 1000)
+	tests := []struct {
+		name    string
+		maxCost float64
+		allowed int
+	}{
+		{"no limit", 0, 5},
+		{"room for two", 0.05, 2},
+		{"room for none", 0.01, 0},
+		{"exactly one", 0.021, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetSpend(t)
+			cfg := testConfig()
+			cfg.MaxCost = tt.maxCost
+			allowed := 0
+			for range 5 {
+				err := guardRequest(context.Background(), cfg, prompt)
+				if err != nil {
+					if !errors.Is(err, errCostLimit) || !errors.Is(err, ErrAborted) {
+						t.Fatalf("error %v, want errCostLimit", err)
+					}
+					break
+				}
+				allowed++
+			}
+			if allowed != tt.allowed {
+				t.Errorf("%d requests allowed, want %d", allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestGuardRequestInterval(t *testing.T) {
+	resetSpend(t)
+	cfg := testConfig()
+	cfg.MinRequestInterval = 50 * time.Millisecond
+
+	start := time.Now()
+	for range 3 {
+		if err := guardRequest(context.Background(), cfg, "prompt"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 2*cfg.MinRequestInterval {
+		t.Errorf("three requests took %s, want at least %s", elapsed, 2*cfg.MinRequestInterval)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := guardRequest(ctx, cfg, "prompt"); !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v while waiting with a cancelled context, want context.Canceled", err)
+	}
+}
+
+// TestMaxCostStopsRequests checks a refused request never reaches the model,
+// and that cached responses do not count against the budget.
+func TestMaxCostStopsRequests(t *testing.T) {
+	resetSpend(t)
+	testRepo(t)
+	cfg, requests := ollamaStub(t, "feat: add login")
+	cfg.NoCache = false
+	cfg.MaxCost = 0.0015
+
+	for range 3 {
+		if _, err := generateWithModel(context.Background(), cfg, "first prompt"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := generateWithModel(context.Background(), cfg, "second prompt")
+	if !errors.Is(err, errCostLimit) {
+		t.Errorf("error %v, want errCostLimit", err)
+	}
+	if exitCode(err) != exitAborted {
+		t.Errorf("exit code %d, want %d", exitCode(err), exitAborted)
+	}
+	if got := len(requests()); got != 1 {
+		t.Errorf("%d requests reached the model, want 1", got)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// runInDryRunRepo implements --dry-run-repo. It copies the staged changes
+// into a detached worktree of HEAD, runs this program again against that
+// worktree, shows the commit it made and removes the worktree. Running the
+// flow in a child process means every exit path, aborts included, comes back
+// here for the clean-up. The commit object is left unreferenced in the
+// shared object store for git gc to collect; no branch moves.
+func runInDryRunRepo() error {
+	dir, err := os.MkdirTemp("", "llamapusher-dry-run-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	if output, err := gitCommand("worktree", "add", "--detach", dir, "HEAD").CombinedOutput(); err != nil {
+		return fmt.Errorf("creating the dry-run worktree failed (%v):\n%s", err, strings.TrimSpace(string(output)))
+	}
+	defer func() {
+		if output, err := gitCommand("worktree", "remove", "--force", dir).CombinedOutput(); err != nil {
+			warnf("removing the dry-run worktree %s failed (%v): %s", dir, err, strings.TrimSpace(string(output)))
+		}
+	}()
+
+	if err := copyStagedChanges(dir); err != nil {
+		return err
+	}
+	head := revParse(dir, "HEAD")
+
+	// The child handles Ctrl-C itself; all that is left to do here is to
+	// wait for it and clean up.
+	signal.Notify(make(chan os.Signal, 1), os.Interrupt, syscall.SIGTERM)
+
+	infof("Dry run in a temporary worktree at %s\n", dir)
+	self, err := os.Executable()
+	if err != nil {
+		self = os.Args[0]
+	}
+	// --dry-run-repo=false also overrides LLAMAPUSHER_DRY_RUN_REPO.
+	cmd := exec.Command(self, append(dryRunChildArgs(os.Args[1:]), "--dry-run-repo=false", "--repo", dir)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	var code dryRunExit
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return err
+		}
+		code = dryRunExit(exitErr.ExitCode())
+	}
+
+	if revParse(dir, "HEAD") != head {
+		show := exec.Command(gitPath, "-C", dir, "show", "--no-color", "HEAD")
+		show.Stdout, show.Stderr = os.Stdout, os.Stderr
+		if err := show.Run(); err != nil {
+			warnf("git show failed: %v", err)
+		}
+		infof("The dry-run commit has been thrown away; your branch is unchanged.\n")
+	}
+	if code != 0 {
+		return code
+	}
+	return nil
+}
+
+// dryRunExit carries the exit status of a failed --dry-run-repo child back
+// to fatal, which exits with it; the child has already reported why.
+type dryRunExit int
+
+func (e dryRunExit) Error() string {
+	return fmt.Sprintf("the dry run exited with status %d", int(e))
+}
+
+// copyStagedChanges applies the index's changes against HEAD to the
+// worktree at dir, staged the same way.
+func copyStagedChanges(dir string) error {
+	patch, err := gitCommand("diff", "--staged", "--binary").Output()
+	if err != nil {
+		return fmt.Errorf("reading the staged changes: %w", err)
+	}
+	if len(patch) == 0 {
+		return nil
+	}
+
+	apply := exec.Command(gitPath, "-C", dir, "apply", "--index", "--whitespace=nowarn")
+	apply.Stdin = bytes.NewReader(patch)
+	if output, err := apply.CombinedOutput(); err != nil {
+		return fmt.Errorf("copying the staged changes to the dry-run worktree failed (%v):\n%s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func revParse(dir, rev string) string {
+	output, _ := exec.Command(gitPath, "-C", dir, "rev-parse", rev).Output()
+	return strings.TrimSpace(string(output))
+}
+
+// dryRunChildArgs drops --dry-run-repo from the command line so the child
+// runs the normal flow; the --repo added after it overrides any given here.
+func dryRunChildArgs(args []string) []string {
+	var out []string
+	for _, arg := range args {
+		name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if strings.HasPrefix(arg, "-") && name == "dry-run-repo" {
+			continue
+		}
+		out = append(out, arg)
+	}
+	return out
+}
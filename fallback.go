@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"slices"
+	"strings"
+)
+
+// isTimeout reports whether err is a model request running out of
+// --timeout, rather than failing some other way.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// givesUpOnTimeout reports whether err should go straight to the
+// --fallback-on-timeout message: retrying a model that was too slow, or
+// trying --fallback-model after it, would only keep CI waiting longer.
+func (c Config) givesUpOnTimeout(err error) bool {
+	return c.FallbackOnTimeout && isTimeout(err)
+}
+
+// fallbackMessage is the --fallback-on-timeout message, built from the
+// changed files alone, e.g. "chore: update 3 files in internal/". The type
+// is --commit-type, else that of an --auto-type rule matching every file,
+// else chore (or the first of --allowed-types).
+func fallbackMessage(cfg *Config) string {
+	files, err := getChangedFiles(cfg)
+	if err != nil {
+		debugf("%v", err)
+	}
+	allowed := cfg.allowedTypes()
+
+	commitType := cfg.CommitType
+	for _, rule := range autoTypeRules {
+		if commitType == "" && rule.matchesAll(files) && slices.Contains(allowed, strings.SplitN(rule.Type, "(", 2)[0]) {
+			commitType = rule.Type
+		}
+	}
+	if commitType == "" {
+		commitType = "chore"
+		if !slices.Contains(allowed, commitType) && len(allowed) > 0 {
+			commitType = allowed[0]
+		}
+	}
+
+	switch dir := commonDir(files); {
+	case len(files) == 1:
+		return fmt.Sprintf("%s: update %s", commitType, files[0])
+	case dir != "":
+		return fmt.Sprintf("%s: update %d files in %s/", commitType, len(files), dir)
+	default:
+		return fmt.Sprintf("%s: update %d files", commitType, len(files))
+	}
+}
+
+// commonDir is the deepest directory holding all of files, "" for the top
+// level.
+func commonDir(files []string) string {
+	if len(files) == 0 {
+		return ""
+	}
+	dir := path.Dir(files[0])
+	for _, file := range files[1:] {
+		for dir != "." && !strings.HasPrefix(file, dir+"/") {
+			dir = path.Dir(dir)
+		}
+	}
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestFallbackMessage(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      []string
+		commitType string
+		allowed    string
+		want       string
+	}{
+		{name: "one file", files: []string{"main.go"}, want: "chore: update main.go"},
+		{name: "one directory", files: []string{"internal/a.go", "internal/b/c.go"}, want: "chore: update 2 files in internal/"},
+		{name: "top level", files: []string{"a.go", "cmd/b.go"}, want: "chore: update 2 files"},
+		{name: "dependencies", files: []string{"go.mod", "go.sum"}, want: "chore(deps): update 2 files"},
+		{name: "docs", files: []string{"README.md"}, want: "docs: update README.md"},
+		{name: "--commit-type", files: []string{"README.md"}, commitType: "fix", want: "fix: update README.md"},
+		{name: "chore not allowed", files: []string{"main.go"}, allowed: "feat,fix", want: "feat: update main.go"},
+		{name: "no allowed types", files: []string{"main.go"}, allowed: ",", want: "chore: update main.go"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := testRepo(t)
+			for _, name := range tt.files {
+				stageFile(t, dir, name, "content of "+name+"\n")
+			}
+			cfg := testConfig()
+			cfg.CommitType, cfg.AllowedTypes = tt.commitType, tt.allowed
+			if got := fallbackMessage(cfg); got != tt.want {
+				t.Errorf("fallbackMessage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// repoOperation describes a merge, rebase or similar that git is in the
+// middle of, found by the file git leaves in the git directory.
+type repoOperation struct {
+	name   string // e.g. "merge"
+	marker string // file or directory under the git directory
+}
+
+var repoOperations = []repoOperation{
+	{"merge", "MERGE_HEAD"},
+	{"rebase", "rebase-merge"},
+	{"rebase", "rebase-apply"},
+	{"cherry-pick", "CHERRY_PICK_HEAD"},
+	{"revert", "REVERT_HEAD"},
+	{"bisect", "BISECT_LOG"},
+}
+
+// gitDirPath resolves name inside the git directory, which is not always
+// .git (worktrees, submodules, GIT_DIR).
+func gitDirPath(name string) (string, error) {
+	output, err := gitCommand("rev-parse", "--git-path", name).Output()
+	if err != nil {
+		return "", err
+	}
+	path := strings.TrimSpace(string(output))
+	if !filepath.IsAbs(path) && repoPath != "" {
+		path = filepath.Join(repoPath, path)
+	}
+	return path, nil
+}
+
+// inProgressOperation returns the operation git is in the middle of, or ""
+// when there is none.
+func inProgressOperation() string {
+	for _, op := range repoOperations {
+		path, err := gitDirPath(op.marker)
+		if err != nil {
+			return ""
+		}
+		if _, err := os.Stat(path); err == nil {
+			return op.name
+		}
+	}
+	return ""
+}
+
+// checkInProgressOperation runs before a commit is generated. Unresolved
+// conflicts are refused, as git would refuse the commit anyway. Otherwise
+// the user is warned, and for a merge, cherry-pick or revert the message git
+// prepared is given to the model so the commit still reads as one.
+func checkInProgressOperation(cfg *Config) error {
+	op := inProgressOperation()
+	if op == "" {
+		return nil
+	}
+	debugf("repository is in the middle of a %s", op)
+
+	output, err := gitCommand("diff", "--name-only", "--diff-filter=U").Output()
+	if err == nil {
+		if conflicted := splitLines(string(output)); len(conflicted) > 0 {
+			return fmt.Errorf("a %s is in progress and %d file(s) still have conflicts: %s; resolve them and git add them first", op, len(conflicted), strings.Join(conflicted, ", "))
+		}
+	}
+
+	switch op {
+	case "bisect":
+		warnf("a bisect is in progress; the commit will be made on the commit being tested")
+		return nil
+	case "rebase":
+		warnf("a rebase is in progress; this commit will be part of it (continue with git rebase --continue)")
+		return nil
+	}
+
+	warnf("a %s is in progress; the generated message replaces the one git prepared", op)
+	prepared := preparedMessage()
+	if prepared == "" {
+		return nil
+	}
+	cfg.SystemPrompt = strings.TrimSpace(cfg.SystemPrompt + " This commit concludes a " + op +
+		". Git prepared this message for it, keep its meaning and describe any conflict resolution in the staged changes: " + prepared)
+	return nil
+}
+
+// preparedMessage is the message git wrote for the commit that concludes a
+// merge, cherry-pick or revert, without its comment lines.
+func preparedMessage() string {
+	path, err := gitDirPath("MERGE_MSG")
+	if err != nil {
+		return ""
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			debugf("reading %s: %v", path, err)
+		}
+		return ""
+	}
+	return stripCommentLines(string(content))
+}
+
+// noChangesAdvice explains an empty staged diff from git status: changes
+// left unstaged or untracked, staged files outside --filter-files, or a
+// clean tree with genuinely nothing to commit.
+func noChangesAdvice(cfg *Config) string {
+	output, err := gitCommand("status", "--porcelain").Output()
+	if err != nil {
+		return "Maybe you forgot to add the files? Try git add . and then run this again."
+	}
+
+	staged, unstaged, untracked := 0, 0, 0
+	for _, line := range splitLines(string(output)) {
+		switch {
+		case strings.HasPrefix(line, "??"):
+			untracked++
+		case len(line) >= 2:
+			if line[0] != ' ' {
+				staged++
+			}
+			if line[1] != ' ' {
+				unstaged++
+			}
+		}
+	}
+
+	switch {
+	case staged > 0 && cfg.FilterFiles != "":
+		return fmt.Sprintf("None of the %d staged file(s) match --filter-files %q.", staged, cfg.FilterFiles)
+	case unstaged > 0 && untracked > 0:
+		return fmt.Sprintf("Nothing is staged, but %d tracked file(s) have changes and %d file(s) are untracked. Stage what you want with git add, or rerun with --add-tracked (changed tracked files) or --add-all (everything).", unstaged, untracked)
+	case unstaged > 0:
+		return fmt.Sprintf("Nothing is staged, but %d tracked file(s) have changes. Stage what you want with git add, or rerun with --add-tracked or --add-all.", unstaged)
+	case untracked > 0:
+		return fmt.Sprintf("Nothing is staged, but there are %d untracked file(s). Stage what you want with git add, or rerun with --add-all.", untracked)
+	}
+	return "The working tree is clean, so there is genuinely nothing to commit."
+}
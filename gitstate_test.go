@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNoChangesAdvice(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T, dir string)
+		filter string
+		want   string
+	}{
+		{
+			name: "clean tree",
+			want: "The working tree is clean, so there is genuinely nothing to commit.",
+		},
+		{
+			name:  "unstaged changes",
+			setup: func(t *testing.T, dir string) { writeTestFile(t, dir, "README.md", "changed\n") },
+			want:  "Nothing is staged, but 1 tracked file(s) have changes. Stage what you want with git add, or rerun with --add-tracked or --add-all.",
+		},
+		{
+			name:  "untracked files",
+			setup: func(t *testing.T, dir string) { writeTestFile(t, dir, "new.go", "package main\n") },
+			want:  "Nothing is staged, but there are 1 untracked file(s). Stage what you want with git add, or rerun with --add-all.",
+		},
+		{
+			name: "unstaged and untracked",
+			setup: func(t *testing.T, dir string) {
+				writeTestFile(t, dir, "README.md", "changed\n")
+				writeTestFile(t, dir, "new.go", "package main\n")
+				writeTestFile(t, dir, "other.go", "package main\n")
+			},
+			want: "Nothing is staged, but 1 tracked file(s) have changes and 2 file(s) are untracked.",
+		},
+		{
+			name:   "staged outside --filter-files",
+			setup:  func(t *testing.T, dir string) { stageFile(t, dir, "main.go", "package main\n") },
+			filter: "*.md",
+			want:   `None of the 1 staged file(s) match --filter-files "*.md".`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := testRepo(t)
+			stageFile(t, dir, "README.md", "readme\n")
+			git(t, "commit", "-q", "-m", "init")
+			if tt.setup != nil {
+				tt.setup(t, dir)
+			}
+
+			if got := noChangesAdvice(&Config{FilterFiles: tt.filter}); !strings.HasPrefix(got, tt.want) {
+				t.Errorf("noChangesAdvice() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestNoChangesExit checks the advice reaches the user, with the no-changes
+// exit code, in a clean and an all-unstaged tree.
+func TestNoChangesExit(t *testing.T) {
+	for name, unstaged := range map[string]bool{"clean": false, "unstaged": true} {
+		t.Run(name, func(t *testing.T) {
+			dir := testRepo(t)
+			stageFile(t, dir, "README.md", "readme\n")
+			git(t, "commit", "-q", "-m", "init")
+			want := "genuinely nothing to commit"
+			if unstaged {
+				writeTestFile(t, dir, "README.md", "changed\n")
+				want = "rerun with --add-tracked or --add-all"
+			}
+
+			_, stderr, code := runCLI(t, dir, "", nil, "--provider", "mock", "--force")
+			if code != exitNoChanges {
+				t.Errorf("exit code %d, want %d", code, exitNoChanges)
+			}
+			if !strings.Contains(stderr, want) {
+				t.Errorf("stderr does not mention %q:\n%s", want, stderr)
+			}
+		})
+	}
+}
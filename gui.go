@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// resultOutput receives the proposal in plain and json format; with
+// --message-only it is the only writer left on stdout.
+var resultOutput io.Writer = os.Stdout
+
+// outputFD is the --output-fd file, nil without it.
+var outputFD *os.File
+
+const messageOnlyHelp = `
+Git GUIs and scripts:
+  --message-only prints just the final message (after --template and
+  formatting) on stdout and never commits; everything else goes to stderr.
+  It works as plain text, or as {"message": ...} with --json. On failure
+  stdout is empty and the exit status is not 0. For example:
+
+    git commit -e -m "$(llamapusher --message-only)"
+
+  lazygit (~/.config/lazygit/config.yml):
+    customCommands:
+      - key: "<c-a>"
+        context: "files"
+        command: 'git commit -e -m "$(llamapusher --message-only)"'
+        output: terminal
+
+  tig (~/.tigrc):
+    bind status A !sh -c 'git commit -e -m "$(llamapusher --message-only)"'
+
+  Clients that fill the message box from a command's output can run
+  llamapusher --message-only directly in the repository.
+
+  Editor plugins that want stdout for the usual output can pass an extra
+  file descriptor instead: --output-fd N writes each final message there,
+  plain or as {"message": ...} with --json, alongside the normal flow. For
+  example, from Node.js:
+
+    const child = spawn("llamapusher", ["--dry-run", "--output-fd", "3"],
+      { stdio: ["ignore", "pipe", "pipe", "pipe"] });
+    child.stdio[3].on("data", (message) => { /* fill the message box */ });
+
+  or from a shell, keeping stdout on the terminal:
+
+    llamapusher --dry-run --output-fd 3 3>message.txt
+`
+
+// setupMessageOnly applies --message-only: a dry run printing the bare
+// message, with stdout kept for it alone. Progress goes to stderr through
+// infoOutput and questions through promptOutput.
+func setupMessageOnly(cfg *Config) {
+	cfg.DryRun = true
+	if cfg.Format == "human" {
+		cfg.Format = "plain"
+	}
+	infoOutput = os.Stderr
+}
+
+// setupOutputFD opens --output-fd, checking the descriptor is open for
+// writing so a plugin that forgot to pass it gets an error up front instead
+// of a commit with the message gone nowhere.
+func setupOutputFD(fd int) error {
+	file := os.NewFile(uintptr(fd), fmt.Sprintf("fd %d", fd))
+	if file == nil {
+		return fmt.Errorf("--output-fd %d is not a valid file descriptor", fd)
+	}
+	if _, err := file.Stat(); err != nil {
+		return fmt.Errorf("--output-fd %d is not open; pass it from the parent process, e.g. 3>file", fd)
+	}
+	if _, err := file.Write(nil); err != nil {
+		return fmt.Errorf("--output-fd %d is not open for writing (%v)", fd, err)
+	}
+	outputFD = file
+	return nil
+}
+
+// writeOutputFD sends msg to --output-fd. A failed write, such as from a
+// plugin that closed its end, only warns; it never stops the commit.
+func writeOutputFD(cfg *Config, msg string) {
+	if outputFD == nil {
+		return
+	}
+	text := msg
+	if cfg.Format == "json" {
+		data, err := json.Marshal(map[string]any{"message": msg})
+		if err != nil {
+			warnf("could not encode the message for --output-fd: %v", err)
+			return
+		}
+		text = string(data)
+	}
+	if _, err := fmt.Fprintln(outputFD, text); err != nil {
+		warnf("could not write the message to --output-fd %d: %v", cfg.OutputFD, err)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// failoverDialTimeout bounds connecting to one of several --ollama-url hosts
+// so a machine that is down does not hold up the next one for long.
+const failoverDialTimeout = 5 * time.Second
+
+// preferredHost is the index in --ollama-url of the host that answered last;
+// later requests in the same run try it first.
+var preferredHost atomic.Int32
+
+// ollamaURLs splits the comma-separated --ollama-url.
+func (c Config) ollamaURLs() []string {
+	var hosts []string
+	for _, host := range strings.Split(c.OllamaURL, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// currentHost is the host the next request goes to first.
+func (c Config) currentHost() string {
+	hosts := c.ollamaURLs()
+	return hosts[int(preferredHost.Load())%len(hosts)]
+}
+
+// postWithFailover sends the request to each --ollama-url host in turn,
+// starting with the one that answered last, until one responds. Errors that
+// another host would not fix, such as a 4xx for a bad request or a missing
+// model, are returned straight away. It reports the host that was used.
+func postWithFailover(ctx context.Context, client *http.Client, cfg *Config, jsonData []byte) (*OllamaResponse, string, error) {
+	hosts := cfg.ollamaURLs()
+	first := int(preferredHost.Load()) % len(hosts)
+
+	var lastErr error
+	for n := range hosts {
+		i := (first + n) % len(hosts)
+		resp, err := postOllama(ctx, client, cfg, hosts[i], jsonData)
+		if err == nil {
+			preferredHost.Store(int32(i))
+			return resp, hosts[i], nil
+		}
+		if !isRetryable(err) {
+			return nil, hosts[i], err
+		}
+		if len(hosts) > 1 {
+			debugf("%s failed: %v; trying the next host", hosts[i], err)
+		}
+		lastErr = err
+	}
+	return nil, "", lastErr
+}
+
+func failoverDialer() func(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: failoverDialTimeout, KeepAlive: 30 * time.Second}
+	return dialer.DialContext
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// languageStopwords are short, very common words used to guess the language
+// of a piece of text. Commit subjects are terse, so the lists favour words
+// that show up in imperative one-liners.
+var languageStopwords = map[string][]string{
+	"english":    {"the", "and", "to", "for", "of", "in", "with", "add", "fix", "update", "remove", "use", "from", "when", "on", "is"},
+	"spanish":    {"el", "la", "los", "las", "de", "del", "para", "con", "en", "y", "que", "por", "añadir", "agregar", "corregir", "actualizar", "eliminar"},
+	"french":     {"le", "la", "les", "des", "du", "de", "pour", "avec", "et", "dans", "une", "un", "ajout", "ajouter", "corriger", "mise", "supprimer"},
+	"german":     {"der", "die", "das", "und", "für", "mit", "von", "zu", "im", "den", "ein", "eine", "hinzufügen", "beheben", "aktualisieren", "entfernen"},
+	"portuguese": {"o", "os", "as", "do", "da", "dos", "das", "para", "com", "em", "e", "que", "adicionar", "corrigir", "atualizar", "remover"},
+	"italian":    {"il", "lo", "gli", "della", "del", "per", "con", "e", "di", "che", "aggiungere", "aggiunto", "correggere", "aggiornare", "rimuovere"},
+	"dutch":      {"de", "het", "een", "en", "van", "voor", "met", "op", "toevoegen", "toegevoegd", "repareren", "bijwerken", "verwijderen"},
+}
+
+// detectLanguage guesses the language of texts. It reports false when there
+// is too little signal, or no clear winner, to be confident.
+func detectLanguage(texts []string) (string, bool) {
+	if lang, ok := detectScript(texts); ok {
+		return lang, true
+	}
+
+	scores := map[string]int{}
+	for _, text := range texts {
+		for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r)
+		}) {
+			for lang, stopwords := range languageStopwords {
+				for _, stopword := range stopwords {
+					if word == stopword {
+						scores[lang]++
+					}
+				}
+			}
+		}
+	}
+
+	best, runnerUp := "", 0
+	for lang := range languageStopwords {
+		switch score := scores[lang]; {
+		case best == "" || score > scores[best]:
+			runnerUp = scores[best]
+			best = lang
+		case score > runnerUp:
+			runnerUp = score
+		}
+	}
+
+	if best == "" || scores[best] < 3 || scores[best] < runnerUp*3/2 {
+		return "", false
+	}
+	return best, true
+}
+
+var backtickRe = regexp.MustCompile("`[^`]*`")
+
+// scriptLanguages are the languages detectScript can recognise.
+var scriptLanguages = []string{"japanese", "korean", "chinese", "russian", "arabic", "greek"}
+
+// languageDetectable reports whether detectLanguage can tell lang apart.
+func languageDetectable(lang string) bool {
+	_, ok := languageStopwords[lang]
+	return ok || slices.Contains(scriptLanguages, lang)
+}
+
+// detectScript recognises languages written in a non-Latin script, where a
+// character count is far more reliable than stopwords.
+func detectScript(texts []string) (string, bool) {
+	counts := map[string]int{}
+	letters := 0
+	for _, text := range texts {
+		for _, r := range text {
+			if !unicode.IsLetter(r) {
+				continue
+			}
+			letters++
+			switch {
+			case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+				counts["japanese"]++
+			case unicode.Is(unicode.Hangul, r):
+				counts["korean"]++
+			case unicode.Is(unicode.Han, r):
+				counts["chinese"]++
+			case unicode.Is(unicode.Cyrillic, r):
+				counts["russian"]++
+			case unicode.Is(unicode.Arabic, r):
+				counts["arabic"]++
+			case unicode.Is(unicode.Greek, r):
+				counts["greek"]++
+			}
+		}
+	}
+
+	// Japanese mixes kana with kanji, so any kana at all tips Han to Japanese.
+	if counts["japanese"] > 0 {
+		counts["japanese"] += counts["chinese"]
+		counts["chinese"] = 0
+	}
+
+	for lang, n := range counts {
+		if letters > 0 && n*2 > letters {
+			return lang, true
+		}
+	}
+	return "", false
+}
+
+// detectRepoLanguage looks at recent commit subjects to match the language a
+// project already writes its history in, defaulting to english.
+func detectRepoLanguage() string {
+	output, err := gitCommand("log", "-n", "30", "--no-merges", "--format=%s").Output()
+	if err != nil {
+		debugf("language detection: git log failed: %v", err)
+		return "english"
+	}
+
+	var subjects []string
+	for _, line := range splitLines(string(output)) {
+		_, subject, _ := splitSubject(line)
+		subjects = append(subjects, subject)
+	}
+
+	lang, ok := detectLanguage(subjects)
+	if !ok {
+		debugf("language detection inconclusive across %d commits, using english", len(subjects))
+		return "english"
+	}
+	debugf("detected commit language: %s", lang)
+	return lang
+}
+
+// checkMessageLanguage implements --check-language: it warns when text is
+// clearly not in the requested language and, with "retry", asks once more
+// with a firmer instruction. Short or ambiguous text is given the benefit of
+// the doubt.
+func checkMessageLanguage(ctx context.Context, cfg *Config, prompt, text string) (string, error) {
+	want := strings.ToLower(cfg.Language)
+	if !languageDetectable(want) {
+		debugf("--check-language: cannot detect %s, skipping the check", cfg.Language)
+		return text, nil
+	}
+
+	got, ok := messageLanguage(text)
+	if !ok || got == want {
+		return text, nil
+	}
+	if cfg.CheckLanguage == "retry" {
+		debugf("the message looks like %s instead of %s, retrying", got, want)
+		retryCfg := *cfg
+		retryCfg.NoCache = true
+		retried, err := sendMessage(ctx, &retryCfg, prompt+"\nWrite the commit message in "+cfg.Language+" only, not in "+got+".")
+		if err != nil {
+			return "", err
+		}
+		if got, ok = messageLanguage(retried); !ok || got == want {
+			return retried, nil
+		}
+		text = retried
+	}
+	warnf("the commit message looks like %s, not %s; a larger model may follow --language better", got, cfg.Language)
+	return text, nil
+}
+
+// messageLanguage guesses the language of a commit message, ignoring the
+// conventional-commit prefix and code in backticks.
+func messageLanguage(text string) (string, bool) {
+	_, subject, rest := splitSubject(strings.TrimSpace(text))
+	return detectLanguage([]string{backtickRe.ReplaceAllString(subject+rest, "")})
+}
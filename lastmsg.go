@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// lastMessageFile keeps the message of a commit that git rejected (a hook
+// failed, for instance) so the next run can offer it again instead of
+// asking the model for a new one.
+const lastMessageFile = "LLAMAPUSHER_LAST_MSG"
+
+func saveLastMessage(msg string) (string, error) {
+	path, err := gitDirPath(lastMessageFile)
+	if err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, []byte(msg+"\n"), 0o644)
+}
+
+func removeLastMessage() {
+	path, err := gitDirPath(lastMessageFile)
+	if err != nil {
+		return
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		debugf("removing %s: %v", path, err)
+	}
+}
+
+// offerLastMessage asks at a terminal whether to reuse the message saved by
+// a failed commit. Declining discards it, so it is only offered once.
+func offerLastMessage(cfg *Config) (string, bool) {
+	path, err := gitDirPath(lastMessageFile)
+	if err != nil {
+		return "", false
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	msg := strings.TrimSpace(string(content))
+	if msg == "" || quiet || cfg.Force || !isTerminal(os.Stdin) {
+		debugf("not offering the saved message in %s", path)
+		return "", false
+	}
+
+	out := promptOutput(cfg)
+	fmt.Fprintf(out, "The last commit failed with this message:\n%s\n%s\n%s\n", dim(cfg.Separator), highlightMessage(msg), dim(cfg.Separator))
+	if !confirm(out, "Reuse it instead of generating a new one?", true) {
+		removeLastMessage()
+		return "", false
+	}
+	return msg, true
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// level is how much --log-level lets through; each level includes the ones
+// above it.
+type level int
+
+const (
+	levelDebug level = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = map[string]level{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"warn":  levelWarn,
+	"error": levelError,
+}
+
+// resolveLogLevel turns --log-level into a level. Without it --verbose
+// means debug and --quiet means error.
+func resolveLogLevel(name string) (level, error) {
+	switch {
+	case name != "":
+		l, ok := levelNames[name]
+		if !ok {
+			return levelInfo, fmt.Errorf("unknown --log-level %q (expected debug, info, warn or error)", name)
+		}
+		return l, nil
+	case verbose:
+		return levelDebug, nil
+	case quiet:
+		return levelError, nil
+	default:
+		return levelInfo, nil
+	}
+}
+
+// debugf writes diagnostics to stderr at debug level, keeping stdout clean
+// for piping.
+func debugf(format string, a ...any) {
+	if logLevel > levelDebug {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "[debug] "+format+"\n", a...)
+}
+
+// infof prints user-facing progress output at info level. It goes to
+// stderr when stdout is reserved for --format json.
+func infof(format string, a ...any) {
+	if logLevel > levelInfo {
+		return
+	}
+	fmt.Fprintf(infoOutput, format, a...)
+}
+
+// warnf writes a warning to stderr at warn level and below.
+func warnf(format string, a ...any) {
+	if logLevel > levelWarn {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Warning: "+format+"\n", a...)
+}
+
+// errorf writes an error to stderr; errors are never filtered.
+func errorf(format string, a ...any) {
+	fmt.Fprintf(os.Stderr, "Error: "+format+"\n", a...)
+}
+
+// fatalf reports a formatted error and exits like fatal.
+func fatalf(format string, a ...any) {
+	fatal(fmt.Errorf(format, a...))
+}
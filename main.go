@@ -3,23 +3,98 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/sergi/go-diff/diffmatchpatch"
 )
 
 const (
-	ollamaURL     = "http://localhost:11434/api/generate"
-	regenerateMsg = "♻️ Regenerate Commit Messages"
-	contentType   = "application/json"
+	defaultOllamaURL = "http://localhost:11434/api/generate"
+	regenerateMsg    = "♻️ Regenerate Commit Messages"
+	contentType      = "application/json"
+
+	// defaultSingleStop ends a single-commit generation at the first blank
+	// line, before small models start explaining themselves.
+	defaultSingleStop = `\n\n`
+
+	defaultPRSystemPrompt = "You write pull request descriptions. Do not preface the description with anything. " +
+		"Start with a short title on its own line, then a blank line, " +
+		"then a markdown body that summarises the changes as bullet points."
+
+	defaultSystemPrompt = "You write git commit messages. Do not preface the commit with anything, " +
+		"use the present tense, return the full sentence, " +
+		"and use the conventional commits specification (<type in lowercase>: <subject>)."
+)
+
+// Exit codes, so scripts can tell why a run failed.
+const (
+	exitError         = 1 // anything not listed below, including invalid flags
+	exitNoChanges     = 2
+	exitAborted       = 3
+	exitProviderError = 4
+	exitNotGitRepo    = 5
+	exitDiffTooLarge  = 6
+	exitInvalidMsg    = 7
+	exitInterrupted   = 130
+)
+
+const exitCodesHelp = `
+Exit codes:
+  0    success
+  1    other error (invalid flags, git failure, ...)
+  2    no changes to describe
+  3    aborted by the user
+  4    the model provider failed
+  5    not inside a git repository
+  6    the diff does not fit the model's context window
+  7    --strict/--allowed-types: the model never produced a valid message
+  130  interrupted (Ctrl-C / SIGTERM)
+`
+
+// The causes callers can tell apart with errors.Is; fatal maps each to its
+// exit code (see exitCodesHelp).
+var (
+	ErrNotGitRepo          = errors.New("this is not a git repository 🙅‍♂️")
+	ErrNoChanges           = errors.New("no changes")
+	ErrAborted             = errors.New("aborted")
+	ErrProviderUnavailable = errors.New("model request failed")
+	ErrMessageTooLarge     = errors.New("the commit diff is too large")
+	ErrInvalidMessage      = errors.New("the commit message failed validation")
+)
+
+var (
+	errEmptyMessage = fmt.Errorf("%w: the model returned an empty message, try a different model or increase --max-output-tokens", ErrProviderUnavailable)
+	errFeeDeclined  = fmt.Errorf("%w: the API fee was declined", ErrAborted)
+	// errUserAborted is returned once the user has said no at a prompt and
+	// been told so; fatal does not repeat it.
+	errUserAborted = fmt.Errorf("%w by user", ErrAborted)
+	// errInterrupted ends a wait for input on Ctrl-C or SIGTERM.
+	errInterrupted = fmt.Errorf("%w: interrupted", ErrAborted)
+	// errPromptPrinted stops --prompt-only once the prompt is out.
+	errPromptPrinted = errors.New("prompt printed")
 )
 
 var (
@@ -32,223 +107,1880 @@ var (
 		"test":     "✅",
 		"chore":    "🔧",
 	}
+
+	// typeAliases are the friendlier names --commit-type also accepts.
+	typeAliases = map[string]string{
+		"feature":       "feat",
+		"features":      "feat",
+		"bug":           "fix",
+		"bugfix":        "fix",
+		"hotfix":        "fix",
+		"doc":           "docs",
+		"documentation": "docs",
+		"styles":        "style",
+		"formatting":    "style",
+		"refactoring":   "refactor",
+		"performance":   "perf",
+		"tests":         "test",
+		"testing":       "test",
+		"deps":          "build",
+		"dependencies":  "build",
+		"chores":        "chore",
+		"maintenance":   "chore",
+	}
+
+	// typeToShortcode mirrors typeToGitmoji with the names from gitmoji.dev,
+	// for --gitmoji-style shortcode.
+	typeToShortcode = map[string]string{
+		"feat":     ":sparkles:",
+		"fix":      ":ambulance:",
+		"docs":     ":memo:",
+		"style":    ":lipstick:",
+		"refactor": ":recycle:",
+		"test":     ":white_check_mark:",
+		"chore":    ":wrench:",
+	}
+
+	// autoTypeRules are checked in order by --auto-type; a rule applies when
+	// every staged file matches one of its patterns. Patterns ending in "/"
+	// match a directory prefix, anything else is a glob against the full path
+	// or the base name.
+	autoTypeRules = []typeRule{
+		{Type: "docs", Patterns: []string{"docs/", "doc/", "*.md", "*.rst", "*.adoc", "LICENSE"}},
+		{Type: "test", Patterns: []string{"test/", "tests/", "testdata/", "__tests__/", "*_test.go", "*.test.*", "*.spec.*", "test_*.py"}},
+		{Type: "chore(deps)", Patterns: []string{"go.mod", "go.sum", "package.json", "package-lock.json", "yarn.lock", "pnpm-lock.yaml", "Cargo.toml", "Cargo.lock", "requirements*.txt", "poetry.lock", "Pipfile", "Pipfile.lock", "Gemfile", "Gemfile.lock", "composer.json", "composer.lock"}},
+	}
+
+	binaryDiffRe = regexp.MustCompile(`^Binary files (.+) and (.+) differ$`)
+
+	quiet    bool
+	verbose  bool
+	logLevel = levelInfo
+	noColor  bool
+
+	infoOutput io.Writer = os.Stdout
+	// stdin is shared by every prompt so buffered answers are not lost
+	// between them when input is piped.
+	stdin    = bufio.NewReader(os.Stdin)
+	repoPath string
+	gitPath  = "git"
 )
 
+// stringList is a flag.Value that collects every occurrence of a repeated flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// optionalInt and optionalFloat are flag values that remember whether they
+// were set, so unset sampling options can be left to the model's defaults.
+type optionalInt struct {
+	value int
+	set   bool
+}
+
+func (o *optionalInt) String() string {
+	if o == nil || !o.set {
+		return ""
+	}
+	return strconv.Itoa(o.value)
+}
+
+func (o *optionalInt) Set(s string) error {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	o.value, o.set = v, true
+	return nil
+}
+
+func (o optionalInt) ptr() *int {
+	if !o.set {
+		return nil
+	}
+	v := o.value
+	return &v
+}
+
+type optionalFloat struct {
+	value float64
+	set   bool
+}
+
+func (o *optionalFloat) String() string {
+	if o == nil || !o.set {
+		return ""
+	}
+	return strconv.FormatFloat(o.value, 'g', -1, 64)
+}
+
+func (o *optionalFloat) Set(s string) error {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	o.value, o.set = v, true
+	return nil
+}
+
+func (o optionalFloat) ptr() *float64 {
+	if !o.set {
+		return nil
+	}
+	v := o.value
+	return &v
+}
+
+type typeRule struct {
+	Type     string
+	Patterns []string
+}
+
+type Config struct {
+	Mode               string
+	Base               string
+	Output             string
+	Commit             string
+	ChangelogFile      string
+	EditPassthrough    bool
+	PrepareCommitMsg   bool
+	DryRun             bool
+	Date               string
+	DryRunRepo         bool
+	Range              string
+	RangeLog           bool
+	ContextCommits     int
+	SmallDiffTokens    int
+	AllowEmpty         bool
+	CommitCommand      string
+	NoFileList         bool
+	KeepHunkHeaders    bool
+	KeepFileHeaders    bool
+	IgnoreWhitespace   bool
+	PreviewDiff        bool
+	OllamaURL          string
+	AuthToken          string
+	NoProxy            bool
+	Offline            bool
+	CACert             string
+	Insecure           bool
+	Timeout            time.Duration
+	FallbackOnTimeout  bool
+	Refine             bool
+	RefinePrompt       string
+	Model              string
+	FallbackModel      string
+	Language           string
+	CheckLanguage      string
+	Template           string
+	NoCommitTemplate   bool
+	Emoji              bool
+	NoEmoji            bool
+	ConfigFile         string
+	Profile            string
+	ListProfiles       bool
+	CommitType         string
+	List               bool
+	Force              bool
+	Yes                bool
+	Signoff            bool
+	Split              bool
+	TUI                bool
+	FilterFee          bool
+	MaxCost            float64
+	MinRequestInterval time.Duration
+	MaxOutputTokens    int
+	MaxInputTokens     int
+	TopP               float64
+	Temperature        float64
+	RepetitionPenalty  float64
+	FilterFiles        string
+	MaxRetries         int
+	RetryDelay         time.Duration
+	SystemPrompt       string
+	Instructions       string
+	Seed               int
+	Mirostat           optionalInt
+	MirostatTau        optionalFloat
+	MirostatEta        optionalFloat
+	TopK               optionalInt
+	MinP               optionalFloat
+	ContextSize        int
+	Stop               stringList
+	ListStop           stringList
+	Protect            stringList
+	Redact             stringList
+	NoDefaultProtect   bool
+	ScanSecrets        bool
+	KeepAlive          string
+	AddAll             bool
+	NoCache            bool
+	CacheTTL           time.Duration
+	AddTracked         bool
+	AutoType           bool
+	Stats              bool
+	Parallel           bool
+	Backfill           bool
+	Explain            bool
+	ShowPrompt         bool
+	Strict             bool
+	AllowedTypes       string
+	Breaking           bool
+	Clipboard          bool
+	BreakingStyle      string
+	PromptOnly         bool
+	MessageOnly        bool
+	OutputFD           int
+	Concurrency        int
+	SubjectCase        string
+	KeepPeriod         bool
+	FirstLineOnly      bool
+	EmojiPosition      string
+	GitmojiStyle       string
+	Format             string
+	JSON               bool
+	Separator          string
+	Provider           string
+	Models             string
+
+	provider        Provider
+	followsTemplate bool                      // the prompt asks for a commit template's structure
+	regenerations   int                       // "Regenerate" rounds so far in list mode
+	lastResponse    *OllamaResponse           // when set, filled with each Ollama response, for --mode benchmark
+	typeSampling    map[string]map[string]any // the config file's per-commit-type sampling settings
+}
+
+// wantsBreakingFooter reports whether messages should end in a
+// "BREAKING CHANGE:" footer. List options are single lines, so they only
+// ever get the "!".
+func (c Config) wantsBreakingFooter() bool {
+	return c.Breaking && !c.List && !c.FirstLineOnly && c.BreakingStyle != "bang"
+}
+
+// allowedTypes is the parsed --allowed-types, or every conventional type
+// when it is not set.
+func (c Config) allowedTypes() []string {
+	if c.AllowedTypes == "" {
+		return conventionalTypes
+	}
+	var types []string
+	for _, t := range strings.Split(c.AllowedTypes, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// redacted returns a copy safe to print in verbose output.
+func (c Config) redacted() Config {
+	if c.AuthToken != "" {
+		c.AuthToken = "<redacted>"
+	}
+	return c
+}
+
+// validateConfig rejects out-of-range values and flag combinations that
+// cannot work together, reporting every problem at once.
+func validateConfig(cfg *Config) error {
+	var errs []error
+	check := func(bad bool, format string, a ...any) {
+		if bad {
+			errs = append(errs, fmt.Errorf(format, a...))
+		}
+	}
+
+	check(quiet && verbose, "--quiet and --verbose cannot be used together")
+	check(isFlagSet("log-level") && (quiet || verbose), "--log-level cannot be combined with --quiet or --verbose")
+	check(cfg.List && cfg.Force, "--list and --force cannot be used together: list mode always asks which message to commit")
+	check(cfg.FirstLineOnly && cfg.Breaking && cfg.BreakingStyle == "footer", "--first-line-only cannot be used with --breaking-style footer: the footer is part of the body")
+	check(cfg.CheckLanguage != "off" && cfg.CheckLanguage != "warn" && cfg.CheckLanguage != "retry", "unknown --check-language %q (expected off, warn or retry)", cfg.CheckLanguage)
+	for _, stop := range unescapeAll(cfg.ListStop) {
+		check(strings.Contains(stop, ";"), "--list-stop %q contains ';', which separates the options", stop)
+	}
+	check(len(cfg.ListStop) > 0 && !cfg.List, "--list-stop only applies to --list")
+	check(cfg.TUI && (cfg.List || cfg.Force || cfg.Split || cfg.DryRun || cfg.Mode != "commit" || cfg.EditPassthrough || cfg.PrepareCommitMsg), "--tui is its own interactive commit flow and cannot be used with --list, --force, --split, --dry-run, --edit-passthrough, --prepare-commit-msg or another --mode")
+	check(cfg.MessageOnly && (cfg.Mode != "commit" || cfg.List || cfg.Force || cfg.Split || cfg.TUI || cfg.EditPassthrough || cfg.PrepareCommitMsg || cfg.DryRunRepo || cfg.PromptOnly),
+		"--message-only prints a single message for --mode commit and cannot be used with --list, --force, --split, --tui, --edit-passthrough, --prepare-commit-msg, --dry-run-repo or --prompt-only")
+	check(cfg.MessageOnly && cfg.Format == "human" && isFlagSet("format"), "--message-only prints the bare message; use --format plain or json")
+	check(cfg.OutputFD < 0 || cfg.OutputFD == 0 && isFlagSet("output-fd"), "--output-fd must be an open file descriptor above 0, e.g. 3")
+	check(cfg.OutputFD > 0 && (cfg.Mode != "commit" || cfg.MessageOnly || cfg.EditPassthrough || cfg.PrepareCommitMsg || cfg.DryRunRepo || cfg.PromptOnly),
+		"--output-fd only applies to --mode commit and cannot be used with --message-only, --edit-passthrough, --prepare-commit-msg, --dry-run-repo or --prompt-only")
+	check(cfg.AllowEmpty && (cfg.Mode != "commit" || cfg.Range != "" || cfg.FilterFiles != "" || cfg.Split || cfg.EditPassthrough || cfg.PrepareCommitMsg || cfg.DryRunRepo),
+		"--allow-empty only applies to committing the staged changes, without --range, --filter-files, --split, --edit-passthrough, --prepare-commit-msg or --dry-run-repo")
+	check(cfg.Refine && cfg.List, "--refine applies to single messages, not --list options")
+	check(cfg.RefinePrompt != "" && !cfg.Refine, "--refine-prompt needs --refine")
+	check(cfg.CommitCommand != "" && (cfg.Date != "" || cfg.FilterFiles != "" || cfg.AllowEmpty), "--date, --filter-files and --allow-empty are git commit options and cannot be used with --commit-command")
+	check(cfg.CommitCommand != "" && (cfg.EditPassthrough || cfg.PrepareCommitMsg || cfg.Mode == "reword"), "--commit-command does not apply to --edit-passthrough, --prepare-commit-msg or --mode reword")
+	check(cfg.MaxCost < 0, "--max-cost must be >= 0")
+	check(cfg.MinRequestInterval < 0, "--min-request-interval must be >= 0")
+	check(cfg.ContextCommits < 0, "--diff-context-commits must be 0 or more")
+	check(cfg.SmallDiffTokens < 0, "--small-diff-tokens must be 0 or more")
+	check(cfg.PromptOnly && (cfg.Split || cfg.TUI || cfg.DryRunRepo), "--prompt-only cannot be used with --split, --tui or --dry-run-repo")
+	check(cfg.Split && (cfg.List || cfg.Force), "--split cannot be used with --list or --force: every split commit is confirmed")
+	check(cfg.Split && (cfg.Mode != "commit" || cfg.Range != "" || cfg.FilterFiles != "" || cfg.EditPassthrough || cfg.PrepareCommitMsg), "--split only works on the staged changes in --mode commit, without --range, --filter-files, --edit-passthrough or --prepare-commit-msg")
+	check(cfg.AddAll && cfg.AddTracked, "--add-all and --add-tracked cannot be used together")
+	for _, pattern := range cfg.Protect {
+		_, err := path.Match(pattern, "")
+		check(err != nil, "invalid --protect pattern %q", pattern)
+	}
+	for _, pattern := range cfg.Redact {
+		_, err := path.Match(pattern, "")
+		check(err != nil, "invalid --redact pattern %q", pattern)
+	}
+	check(!slices.Contains([]string{"commit", "pr", "changelog", "reword", "benchmark"}, cfg.Mode), "unknown --mode %q (expected commit, pr, changelog, reword or benchmark)", cfg.Mode)
+	check(isFlagSet("commit") && cfg.Mode != "reword", "--commit only applies to --mode reword")
+	check(cfg.Mode == "reword" && (cfg.FilterFiles != "" || cfg.AddAll || cfg.AddTracked), "--mode reword describes whole commits and cannot be used with --filter-files, --add-all or --add-tracked")
+	check(cfg.Mode == "reword" && cfg.Range != "" && isFlagSet("commit"), "--mode reword takes either --commit or --range, not both")
+	check(cfg.Models != "" && cfg.Mode != "benchmark", "--models only applies to --mode benchmark")
+	check(cfg.Mode != "commit" && cfg.List, "--list only applies to --mode commit")
+	check(cfg.Range != "" && (cfg.AddAll || cfg.AddTracked), "--add-all/--add-tracked have no effect with --range")
+	check(cfg.DryRun && cfg.DryRunRepo, "--dry-run and --dry-run-repo cannot be used together")
+	check((cfg.DryRun || cfg.DryRunRepo) && (cfg.EditPassthrough || cfg.PrepareCommitMsg), "--dry-run/--dry-run-repo cannot be used inside git commit (--edit-passthrough, --prepare-commit-msg)")
+	check(cfg.DryRunRepo && (cfg.Mode != "commit" || cfg.Range != ""), "--dry-run-repo only applies to committing the staged changes")
+	check(cfg.DryRunRepo && (cfg.AddAll || cfg.AddTracked), "--dry-run-repo copies what is already staged; stage first instead of --add-all/--add-tracked")
+	check(cfg.EditPassthrough && cfg.PrepareCommitMsg, "--edit-passthrough and --prepare-commit-msg cannot be used together")
+	check(cfg.PrepareCommitMsg && (cfg.Mode != "commit" || cfg.List || cfg.Force || cfg.AddAll || cfg.AddTracked || cfg.Range != ""),
+		"--prepare-commit-msg runs inside git commit and cannot be combined with --mode, --list, --force, --add-all, --add-tracked or --range")
+	check(cfg.EditPassthrough && (cfg.Mode != "commit" || cfg.List || cfg.Force || cfg.AddAll || cfg.AddTracked || cfg.Range != ""),
+		"--edit-passthrough runs inside git commit and cannot be combined with --mode, --list, --force, --add-all, --add-tracked or --range")
+	check(cfg.AllowedTypes != "" && len(cfg.allowedTypes()) == 0, "--allowed-types lists no types")
+	for _, t := range cfg.allowedTypes() {
+		check(!regexp.MustCompile(`^[a-z]+$`).MatchString(t), "--allowed-types: %q is not a lowercase commit type", t)
+	}
+	baseType := strings.SplitN(cfg.CommitType, "(", 2)[0]
+	check(cfg.AllowedTypes != "" && cfg.CommitType != "" && !slices.Contains(cfg.allowedTypes(), baseType),
+		"--commit-type %q is not in --allowed-types", cfg.CommitType)
+	check(cfg.Strict && cfg.AllowedTypes == "" && cfg.CommitType != "" && !slices.Contains(conventionalTypes, baseType),
+		"--commit-type %q is not a conventional commit type (%s), which --strict requires", cfg.CommitType, strings.Join(conventionalTypes, ", "))
+	check(cfg.BreakingStyle != "bang" && cfg.BreakingStyle != "footer" && cfg.BreakingStyle != "both", "--breaking-style must be bang, footer or both")
+	check(isFlagSet("breaking-style") && !cfg.Breaking, "--breaking-style needs --breaking")
+	check(cfg.Explain && (cfg.Mode != "commit" || cfg.List || cfg.EditPassthrough || cfg.PrepareCommitMsg), "--explain only applies to a single proposed commit message")
+	check(cfg.Output != "" && cfg.Mode != "pr", "--output only applies to --mode pr")
+	check(cfg.SubjectCase != "lower" && cfg.SubjectCase != "sentence" && cfg.SubjectCase != "preserve", "--subject-case must be lower, sentence or preserve")
+	check(cfg.EmojiPosition != "prefix" && cfg.EmojiPosition != "after-type" && cfg.EmojiPosition != "suffix", "--emoji-position must be prefix, after-type or suffix")
+	check(cfg.GitmojiStyle != "unicode" && cfg.GitmojiStyle != "shortcode", "--gitmoji-style must be unicode or shortcode")
+	for _, host := range cfg.ollamaURLs() {
+		u, err := url.Parse(host)
+		check(err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "", "--ollama-url: %q is not an http(s) URL", host)
+	}
+	check(len(cfg.ollamaURLs()) == 0, "--ollama-url must name at least one endpoint")
+	check(cfg.Format != "human" && cfg.Format != "plain" && cfg.Format != "json", "--format must be human, plain or json")
+	check(cfg.JSON && isFlagSet("format") && cfg.Format != "json", "--json and --format %s cannot be used together", cfg.Format)
+	check(cfg.Concurrency < 1, "--concurrency must be >= 1")
+	check(cfg.Temperature < 0, "--temperature must be >= 0")
+	check(cfg.TopP < 0 || cfg.TopP > 1, "--top-p must be between 0 and 1")
+	check(cfg.RepetitionPenalty < 0, "--repetition-penalty must be >= 0")
+	check(cfg.MaxOutputTokens < 1, "--max-output-tokens must be >= 1")
+	check(cfg.MaxInputTokens < 0, "--max-input-tokens must be >= 0")
+	check(cfg.MaxRetries < 0, "--max-retries must be >= 0")
+	check(cfg.RetryDelay < 0, "--retry-delay must be >= 0")
+	check(cfg.ContextSize < 0, "--context-size must be >= 0")
+	check(cfg.Timeout < 0, "--timeout must be >= 0")
+	check(cfg.FallbackOnTimeout && cfg.Timeout == 0, "--fallback-on-timeout needs a --timeout")
+	check(cfg.FallbackOnTimeout && (cfg.List || cfg.Mode != "commit"), "--fallback-on-timeout only applies to a single commit message in --mode commit")
+	check(cfg.CacheTTL < 0, "--cache-ttl must be >= 0")
+	check(cfg.Mirostat.set && (cfg.Mirostat.value < 0 || cfg.Mirostat.value > 2), "--mirostat must be 0, 1 or 2")
+	check(cfg.MirostatTau.set && cfg.MirostatTau.value < 0, "--mirostat-tau must be >= 0")
+	check(cfg.MirostatEta.set && cfg.MirostatEta.value < 0, "--mirostat-eta must be >= 0")
+	check(cfg.TopK.set && cfg.TopK.value < 0, "--top-k must be >= 0")
+	check(cfg.MinP.set && (cfg.MinP.value < 0 || cfg.MinP.value > 1), "--min-p must be between 0 and 1")
+	check(cfg.Seed < -1, "--seed must be >= 0, or -1 for a random seed")
+
+	if _, err := parseKeepAlive(cfg.KeepAlive); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Date != "" {
+		if _, err := parseCommitDate(cfg.Date); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
 type OllamaRequest struct {
-	Model             string `json:"model"`
-	Prompt            string `json:"prompt"`
-	Stream            bool   `json:"stream"`
-	MaxTokens         int    `json:"max_tokens"`
-	TopP              int    `json:"top_p"`
-	Temperature       int    `json:"temperature"`
-	RepetitionPenalty int    `json:"repetition_penalty"`
+	Model     string        `json:"model"`
+	Prompt    string        `json:"prompt"`
+	System    string        `json:"system,omitempty"`
+	Stream    bool          `json:"stream"`
+	KeepAlive any           `json:"keep_alive,omitempty"`
+	Options   OllamaOptions `json:"options"`
+}
+
+// OllamaOptions holds the model parameters Ollama reads from "options";
+// sampling settings sent at the top level of the request are ignored.
+type OllamaOptions struct {
+	TopP          float64  `json:"top_p"`
+	Temperature   float64  `json:"temperature"`
+	RepeatPenalty float64  `json:"repeat_penalty"`
+	Seed          *int     `json:"seed,omitempty"`
+	NumCtx        int      `json:"num_ctx,omitempty"`
+	NumPredict    int      `json:"num_predict,omitempty"`
+	Stop          []string `json:"stop,omitempty"`
+	Mirostat      *int     `json:"mirostat,omitempty"`
+	MirostatTau   *float64 `json:"mirostat_tau,omitempty"`
+	MirostatEta   *float64 `json:"mirostat_eta,omitempty"`
+	TopK          *int     `json:"top_k,omitempty"`
+	MinP          *float64 `json:"min_p,omitempty"`
 }
 
 type OllamaResponse struct {
-	Response string `json:"response"`
+	Response           string `json:"response"`
+	Error              string `json:"error"`
+	Done               bool   `json:"done"`
+	DoneReason         string `json:"done_reason"`
+	TotalDuration      int64  `json:"total_duration"`
+	PromptEvalCount    int    `json:"prompt_eval_count"`
+	PromptEvalDuration int64  `json:"prompt_eval_duration"`
+	EvalCount          int    `json:"eval_count"`
+	EvalDuration       int64  `json:"eval_duration"`
+}
+
+type httpStatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *httpStatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("ollama returned HTTP %d", e.StatusCode)
+	}
+	return fmt.Sprintf("ollama returned HTTP %d: %s", e.StatusCode, e.Message)
 }
 
 func main() {
-	model             := flag.String("model", "tinydolphin:1.1b-v2.8-q5_K_M", "The model to use for generating commit messages")
-	language          := flag.String("language", "english", "The language to use for generating commit messages")
-	template          := flag.String("template", "", "The template to use for formatting commit messages")
-	emoji             := flag.Bool("emoji", true, "Add gitmoji to the commit message")
-	commitType        := flag.String("commit-type", "", "The type of commit (e.g., feat, fix, docs)")
-	list              := flag.Bool("list", false, "Generate a list of commit message options")
-	force             := flag.Bool("force", false, "Force the commit without prompting for confirmation")
-	filterFee         := flag.Bool("filter-fee", false, "Display the approximate fee for using the API")
-	maxTokens         := flag.Int("max-tokens", 2048, "The maximum number of tokens to generate")
-	topP              := flag.Int("top-p", 1, "The top-p sampling value")
-	temperature       := flag.Int("temperature", 1, "The temperature value for sampling")
-	repetitionPenalty := flag.Int("repetition-penalty", 1, "The repetition penalty value")
-	filterFiles				:= flag.String("filter-files", "", "Filter files to commit (glob pattern)")
+	cfg := &Config{}
+	flag.StringVar(&cfg.Mode, "mode", "commit", "What to generate: commit, pr for a pull request description, changelog for a CHANGELOG.md entry, reword to rewrite the message of --commit, or benchmark to compare --models on the staged diff")
+	flag.StringVar(&cfg.Commit, "commit", "HEAD", "The commit to regenerate the message of in --mode reword; use --range instead to reword every commit in a range")
+	flag.StringVar(&cfg.Models, "models", "", "Comma-separated models to compare in --mode benchmark (default: --model)")
+	flag.StringVar(&cfg.Base, "base", "", "The branch to diff against in pr mode (default: the remote's default branch)")
+	flag.StringVar(&cfg.Output, "output", "", "Write the generated pr description to this file instead of stdout")
+	flag.StringVar(&cfg.ChangelogFile, "changelog-file", "CHANGELOG.md", "The changelog updated in changelog mode, relative to the top of the repository")
+	flag.StringVar(&cfg.Range, "range", "", "Describe a revision range (e.g. main..HEAD) instead of the staged changes; in pr mode this replaces --base")
+	flag.BoolVar(&cfg.KeepHunkHeaders, "keep-hunk-headers", false, "Keep the @@ hunk headers (line numbers and enclosing function) in the diff sent to the model")
+	flag.BoolVar(&cfg.KeepFileHeaders, "keep-file-headers", false, "Keep the \"diff --git\" line that starts each file in the diff sent to the model")
+	flag.BoolVar(&cfg.PreviewDiff, "preview-diff", false, "Show the diff that will be sent to the model, and at a terminal ask before generating")
+	flag.BoolVar(&cfg.IgnoreWhitespace, "ignore-whitespace", false, "Leave whitespace-only changes out of the diff sent to the model, unless nothing else changed")
+	flag.BoolVar(&cfg.NoFileList, "no-file-list", false, "Do not list the changed file names in the prompt")
+	flag.IntVar(&cfg.ContextCommits, "diff-context-commits", 0, "Include the --stat summaries of this many commits before the changes in the prompt, so the model does not describe what is already committed; costs tokens, 0 turns it off")
+	flag.BoolVar(&cfg.RangeLog, "range-log", false, "Include the subjects of the commits in --range in the prompt")
+	flag.BoolVar(&cfg.PrepareCommitMsg, "prepare-commit-msg", false, "Run as a prepare-commit-msg hook (pre-commit framework or .git/hooks): prefill the message file passed as the first argument, building on any message already started in it")
+	flag.BoolVar(&cfg.EditPassthrough, "edit-passthrough", false, "Act as GIT_EDITOR: prefill the message file git passes in, then open your real editor (GIT_EDITOR=\"llamapusher --edit-passthrough\" git commit)")
+	flag.StringVar(&cfg.Provider, "provider", "ollama", "Where messages come from: ollama, or mock for offline runs and CI (canned $LLAMAPUSHER_MOCK_RESPONSE, else a message naming the first changed file)")
+	flag.StringVar(&cfg.OllamaURL, "ollama-url", defaultOllamaURL, "The Ollama generate endpoint; a comma-separated list fails over from one host to the next")
+	flag.StringVar(&cfg.AuthToken, "auth-token", os.Getenv("LLAMAPUSHER_AUTH_TOKEN"), "Bearer token sent in the Authorization header (default $LLAMAPUSHER_AUTH_TOKEN)")
+	flag.BoolVar(&cfg.Offline, "offline", false, "Refuse to run unless every --ollama-url is on this machine (localhost or a loopback address), so code is never sent elsewhere by mistake; an extra safeguard, not a replacement for checking your configuration")
+	flag.BoolVar(&cfg.NoProxy, "no-proxy", false, "Ignore HTTP_PROXY/HTTPS_PROXY/NO_PROXY and connect to the endpoint directly")
+	flag.StringVar(&cfg.CACert, "ca-cert", "", "Path to a PEM CA bundle trusted for HTTPS endpoints")
+	flag.BoolVar(&cfg.Insecure, "insecure", false, "Skip TLS certificate verification (testing only)")
+	flag.DurationVar(&cfg.Timeout, "timeout", 0, "Give up on a model request after this long (e.g. 90s); 0 waits indefinitely")
+	flag.BoolVar(&cfg.FallbackOnTimeout, "fallback-on-timeout", false, "When the model does not answer within --timeout, commit a basic message built from the changed files (e.g. \"chore: update 3 files in internal/\") instead of failing")
+	flag.StringVar(&cfg.Model, "model", "tinydolphin:1.1b-v2.8-q5_K_M", "The model to use for generating commit messages")
+	flag.StringVar(&cfg.FallbackModel, "fallback-model", "", "A model to retry with if the primary model errors or is missing")
+	flag.StringVar(&cfg.Language, "language", "english", "The language to use for generating commit messages, or auto to match the repository's recent commits")
+	flag.StringVar(&cfg.CheckLanguage, "check-language", "off", "Check that the message is in --language: off, warn, or retry once with a firmer instruction before warning")
+	flag.StringVar(&cfg.Template, "template", "", "The template to use for formatting commit messages; takes precedence over the repository's commit.template or .gitmessage")
+	flag.BoolVar(&cfg.NoCommitTemplate, "no-commit-template", false, "Ignore the repository's commit.template and .gitmessage")
+	flag.BoolVar(&cfg.Emoji, "emoji", true, "Add gitmoji to the commit message")
+	flag.StringVar(&cfg.GitmojiStyle, "gitmoji-style", "unicode", "How the gitmoji is written: unicode (✨) or shortcode (:sparkles:)")
+	flag.BoolVar(&cfg.NoEmoji, "no-emoji", false, "Shorthand for --emoji=false")
+	flag.StringVar(&cfg.EmojiPosition, "emoji-position", "prefix", "Where the gitmoji goes: prefix (✨ feat: x), after-type (feat: ✨ x) or suffix (feat: x ✨)")
+	flag.StringVar(&cfg.CommitType, "commit-type", "", "The type of commit (e.g., feat, fix, docs, optionally with a scope: feat(api)); aliases such as feature, bugfix and documentation are expanded")
+	flag.BoolVar(&cfg.List, "list", false, "Generate a list of commit message options")
+	flag.BoolVar(&cfg.Parallel, "parallel", false, "In list mode, generate each option with its own request (seed and temperature varied) instead of one prompt")
+	flag.BoolVar(&cfg.Refine, "refine", false, "Generate a draft, then send it back with the diff for the model to tighten; often better with mid-size models, at the cost of a second request")
+	flag.StringVar(&cfg.RefinePrompt, "refine-prompt", "", "A text/template file replacing the --refine prompt; it can use {{.Draft}}, {{.Diff}} and {{.Language}}")
+	flag.BoolVar(&cfg.Explain, "explain", false, "After proposing a message, ask the model to justify it against the diff (one extra request; never added to the commit)")
+	flag.BoolVar(&cfg.Backfill, "backfill", false, "In list mode, request more options when duplicates leave fewer than five distinct ones")
+	flag.IntVar(&cfg.Concurrency, "concurrency", 2, "How many --parallel requests run at once; a single Ollama server queues them unless OLLAMA_NUM_PARALLEL is raised")
+	flag.BoolVar(&cfg.Clipboard, "clipboard", false, "Copy the chosen message to the clipboard (pbcopy, wl-copy, xclip, xsel or clip.exe); answer n to copy without committing")
+	flag.StringVar(&cfg.Date, "date", "", "Commit with this date (e.g. 2024-05-01 14:30 or RFC 3339); sets both the author and the committer date")
+	flag.BoolVar(&cfg.DryRun, "dry-run", false, "Generate and show the message without committing")
+	flag.BoolVar(&cfg.DryRunRepo, "dry-run-repo", false, "Run the whole flow, commit included, in a throwaway worktree holding a copy of the staged changes, print the resulting git show, then delete it")
+	flag.BoolVar(&cfg.Force, "force", false, "Force the commit without prompting for confirmation")
+	flag.BoolVar(&cfg.TUI, "tui", false, "Interactive screen to review, edit and regenerate the message (changing model, temperature or emoji) before committing; the normal flow is used when not at a terminal")
+	flag.BoolVar(&cfg.Split, "split", false, "Experimental: split the staged changes into one commit per directory or kind of file (docs, tests, dependencies), each confirmed; rewrites the staging area while it runs")
+	flag.StringVar(&cfg.CommitCommand, "commit-command", "", "Commit with this shell command instead of git commit, e.g. 'jj describe --stdin && jj new'; it gets the message on stdin and in $LLAMAPUSHER_MESSAGE_FILE, and has to move HEAD")
+	flag.BoolVar(&cfg.AllowEmpty, "allow-empty", false, "Commit even with nothing staged (git commit --allow-empty), e.g. to trigger CI; the message is then based on the branch name and recent commits")
+	flag.BoolVar(&cfg.Signoff, "signoff", false, "Add a Signed-off-by trailer with your git identity when committing, like git commit -s")
+	flag.BoolVar(&cfg.Signoff, "s", false, "Shorthand for --signoff")
+	flag.BoolVar(&cfg.Yes, "yes", false, "Make Enter accept the proposed commit message at the confirmation prompt; when stdin is not a terminal and has no input, accept it without asking")
+	flag.BoolVar(&cfg.FilterFee, "filter-fee", false, "Display the approximate fee for using the API")
+	flag.Float64Var(&cfg.MaxCost, "max-cost", 0, "Refuse further model requests once the estimated spend of this run (the --filter-fee estimate) would pass this many dollars; 0 means no limit")
+	flag.DurationVar(&cfg.MinRequestInterval, "min-request-interval", 0, "Wait at least this long between model requests (e.g. 2s), to rate-limit paid endpoints; 0 means no wait")
+	flag.IntVar(&cfg.MaxOutputTokens, "max-output-tokens", 2048, "The maximum number of tokens to generate (num_predict)")
+	flag.IntVar(&cfg.MaxOutputTokens, "max-tokens", 2048, "Deprecated: use --max-output-tokens")
+	flag.IntVar(&cfg.MaxInputTokens, "max-input-tokens", 0, "Reject diffs estimated above this many tokens; 0 checks against the model's context window only (2048 when it is unknown)")
+	flag.Float64Var(&cfg.TopP, "top-p", 1, "The top-p sampling value")
+	flag.Float64Var(&cfg.Temperature, "temperature", 1, "The temperature value for sampling")
+	flag.Float64Var(&cfg.RepetitionPenalty, "repetition-penalty", 1, "The repetition penalty value")
+	flag.IntVar(&cfg.Seed, "seed", -1, "Random seed for reproducible output; combine with --temperature 0 (results also depend on the model version). -1 picks a random seed")
+	flag.Var(&cfg.Mirostat, "mirostat", "Mirostat sampling: 0 off, 1 Mirostat, 2 Mirostat 2.0 (default: model setting)")
+	flag.Var(&cfg.MirostatTau, "mirostat-tau", "Mirostat target entropy; lower gives more focused, coherent text (default: model setting)")
+	flag.Var(&cfg.MirostatEta, "mirostat-eta", "Mirostat learning rate; higher reacts faster to feedback (default: model setting)")
+	flag.Var(&cfg.TopK, "top-k", "Sample only from the K most likely tokens; lower is more conservative (default: model setting)")
+	flag.Var(&cfg.MinP, "min-p", "Drop tokens less likely than this fraction of the top token (default: model setting)")
+	flag.BoolVar(&cfg.AddAll, "add-all", false, "Stage all changes, including untracked files, before generating (git add -A)")
+	flag.BoolVar(&cfg.AddAll, "a", false, "Shorthand for --add-all")
+	flag.BoolVar(&cfg.AddTracked, "add-tracked", false, "Stage changes to tracked files only before generating (git add -u)")
+	flag.BoolVar(&cfg.AddTracked, "u", false, "Shorthand for --add-tracked")
+	flag.StringVar(&cfg.SubjectCase, "subject-case", "preserve", "Normalise the first letter of the subject: lower, sentence or preserve")
+	flag.BoolVar(&cfg.Strict, "strict", false, "Regenerate until the message is a valid Conventional Commit (type(scope)!: subject) and refuse to commit otherwise")
+	flag.StringVar(&cfg.AllowedTypes, "allowed-types", "", "Comma-separated commit types the model may use (e.g. feat,fix,chore,docs); others are regenerated (default: the full conventional set)")
+	flag.BoolVar(&cfg.Breaking, "breaking", false, "Mark the commit as a breaking change, as set by --breaking-style")
+	flag.StringVar(&cfg.BreakingStyle, "breaking-style", "both", "How --breaking is marked: bang (feat!: x), footer (a BREAKING CHANGE: footer describing the breakage) or both; list options only get the bang")
+	flag.BoolVar(&cfg.FirstLineOnly, "first-line-only", false, "Keep only the first non-empty line of the model's reply as the commit message")
+	flag.BoolVar(&cfg.KeepPeriod, "keep-period", false, "Keep a trailing period on the subject line instead of removing it")
+	flag.BoolVar(&cfg.AutoType, "auto-type", false, "Detect the commit type from the staged files when --commit-type is not given")
+	flag.StringVar(&cfg.FilterFiles, "filter-files", "", "Only describe and commit staged files matching this git pathspec (e.g. '*.go'); other staged files stay staged")
+	flag.IntVar(&cfg.MaxRetries, "max-retries", 3, "How many times to retry when Ollama is unreachable or returns a 5xx error")
+	flag.DurationVar(&cfg.RetryDelay, "retry-delay", time.Second, "Initial delay between retries, doubled after each attempt")
+	flag.IntVar(&cfg.SmallDiffTokens, "small-diff-tokens", 40, "Diffs whose added and removed lines come to fewer estimated tokens than this get a stricter one-line prompt, so tiny changes are not over-explained; 0 turns it off")
+	flag.IntVar(&cfg.ContextSize, "context-size", 0, "The context window (num_ctx) to request from Ollama; 0 uses the server default")
+	flag.Var(&cfg.Protect, "protect", "Refuse to commit staged files matching this glob, e.g. 'config/*.yaml'; repeatable, added to a built-in list of common secret files")
+	flag.Var(&cfg.Redact, "redact", "Send only a \"(redacted N changed lines in <file>)\" note instead of the changes to files matching this glob; repeatable")
+	flag.BoolVar(&cfg.NoDefaultProtect, "no-default-protect", false, "Do not protect the built-in list of common secret files (.env, *.pem, id_rsa, ...)")
+	flag.BoolVar(&cfg.ScanSecrets, "scan-secrets", false, "Scan the diff for keys, tokens and other secrets before sending it to the model, and ask before going on")
+	flag.Var(&cfg.Stop, "stop", "A stop sequence that ends generation; repeatable, escapes like \\n are honoured (default \"\\n\\n\" for single commits and each --parallel or regenerated list option)")
+	flag.Var(&cfg.ListStop, "list-stop", "A stop sequence for the single request that returns all --list options; repeatable (default none, --stop does not apply to it)")
+	flag.StringVar(&cfg.KeepAlive, "keep-alive", "", "How long Ollama keeps the model loaded after the request (e.g. 10m, or -1 to keep it loaded, at the cost of holding its memory)")
+	flag.StringVar(&cfg.Instructions, "instructions", "", "Extra instructions for this run, added to the prompt ahead of the diff (e.g. \"mention ticket ABC-123\"); the system prompt is kept")
+	flag.StringVar(&cfg.Instructions, "i", "", "Shorthand for --instructions")
+	flag.StringVar(&cfg.SystemPrompt, "system-prompt", defaultSystemPrompt, "The system prompt sent alongside the diff")
+	flag.BoolVar(&cfg.NoCache, "no-cache", false, "Always call the model instead of reusing a cached message for the same diff")
+	flag.DurationVar(&cfg.CacheTTL, "cache-ttl", 24*time.Hour, "How long cached messages are reused")
+	flag.StringVar(&cfg.ConfigFile, "config", "", "JSON file of default flag values keyed by flag name, overridden by the command line (default "+defaultConfigPath()+")")
+	flag.StringVar(&cfg.Profile, "profile", "", "Use the named preset from \"profiles\" in the config file; explicit flags still win")
+	flag.BoolVar(&cfg.ListProfiles, "list-profiles", false, "List the profiles in the config file and exit")
+	flag.StringVar(&gitPath, "git-path", "git", "The git executable to run")
+	flag.StringVar(&repoPath, "repo", "", "Run against the git repository or worktree at this path instead of the current directory")
+	flag.BoolVar(&cfg.ShowPrompt, "show-prompt", false, "Print the full prompt, diff included, to stderr before each request")
+	flag.IntVar(&cfg.OutputFD, "output-fd", 0, "Also write the final message to this already open file descriptor, keeping stdout for the usual output; for editor plugins (see below)")
+	flag.BoolVar(&cfg.MessageOnly, "message-only", false, "Print only the final message on stdout and do not commit, with everything else on stderr; for git GUIs and scripts (see below)")
+	flag.BoolVar(&cfg.PromptOnly, "prompt-only", false, "Print the first prompt that would be sent to stdout and exit without calling the model")
+	flag.BoolVar(&cfg.Stats, "stats", false, "Print token counts, tokens/sec and timing for each generation to stderr")
+	flag.StringVar(&cfg.Format, "format", "human", "How proposals are printed: human, plain (the bare message; the default with --quiet) or json")
+	flag.BoolVar(&cfg.JSON, "json", false, "Shorthand for --format json")
+	flag.StringVar(&cfg.Separator, "separator", defaultSeparator, "The line around the proposed message in --format human")
+	flag.BoolVar(&noColor, "no-color", false, "Disable coloured output (also disabled by NO_COLOR or when stdout is not a terminal)")
+	flag.BoolVar(&quiet, "quiet", false, "Only print the final commit message and errors")
+	flag.BoolVar(&verbose, "verbose", false, "Print debug information (config, prompt, raw response) to stderr")
+	logLevelName := flag.String("log-level", "", "Diagnostics to print to stderr: debug, info, warn or error (default info, debug with --verbose, error with --quiet)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprint(flag.CommandLine.Output(), envHelp)
+		fmt.Fprint(flag.CommandLine.Output(), messageOnlyHelp)
+		fmt.Fprint(flag.CommandLine.Output(), exitCodesHelp)
+	}
+	// "llamapusher benchmark ..." is shorthand for --mode benchmark.
+	if len(os.Args) > 1 && os.Args[1] == "benchmark" {
+		os.Args = append([]string{os.Args[0], "--mode=benchmark"}, os.Args[2:]...)
+	}
 	flag.Parse()
+	if err := applyEnvironment(); err != nil {
+		fatal(err)
+	}
+	if err := applyGitConfig(); err != nil {
+		fatal(err)
+	}
+	recordExplicitFlags()
+	logLevel, _ = resolveLogLevel(*logLevelName)
 
-	fmt.Printf("AI provider: ollama, Model: %s\n", *model)
+	// --emoji and --no-emoji on the command line, in the environment or in
+	// git config all beat the config file, so they are resolved against
+	// each other before it is read.
+	emojiOnCommandLine := isFlagSet("emoji")
+	if emojiOnCommandLine && cfg.Emoji && cfg.NoEmoji {
+		fatal(errors.New("--emoji and --no-emoji cannot be used together"))
+	}
+	configPath := cfg.ConfigFile
+	if configPath == "" {
+		configPath = defaultConfigPath()
+	}
+	configFile, err := loadConfigFile(configPath, cfg.ConfigFile != "")
+	if err != nil {
+		fatal(err)
+	}
+	if cfg.ListProfiles {
+		configFile.printProfiles()
+		return
+	}
+	if err := configFile.apply(cfg.Profile); err != nil {
+		fatal(err)
+	}
+	cfg.typeSampling = configFile.types
+	if cfg.NoEmoji && !emojiOnCommandLine {
+		cfg.Emoji = false
+	}
+	useColor = colorEnabled(noColor)
+	if logLevel, err = resolveLogLevel(*logLevelName); err != nil {
+		fatal(err)
+	}
+
+	if isFlagSet("max-tokens") {
+		warnf("--max-tokens is deprecated and only sets the output length; use --max-output-tokens, and --max-input-tokens to limit the diff size")
+	}
+
+	if commitType := normalizeCommitType(cfg.CommitType); commitType != cfg.CommitType {
+		debugf("--commit-type %s is %s", cfg.CommitType, commitType)
+		cfg.CommitType = commitType
+	}
+	if err := validateConfig(cfg); err != nil {
+		fatal(err)
+	}
+	switch {
+	case cfg.JSON:
+		cfg.Format = "json"
+	case quiet && !isFlagSet("format"):
+		cfg.Format = "plain"
+	}
+	if cfg.Format == "json" {
+		infoOutput = os.Stderr
+	}
+	if cfg.MessageOnly {
+		setupMessageOnly(cfg)
+	}
+	if cfg.OutputFD > 0 {
+		if err := setupOutputFD(cfg.OutputFD); err != nil {
+			fatal(err)
+		}
+	}
+	provider, err := newProvider(cfg)
+	if err != nil {
+		fatal(err)
+	}
+	cfg.provider = provider
+
+	if _, err := exec.LookPath(gitPath); err != nil {
+		fatalf("Could not find git (%s) 🙅 Install it from https://git-scm.com/downloads or point --git-path at it.", gitPath)
+	}
 
 	if !checkGitRepository() {
-		log.Fatal("This is not a git repository 🙅‍♂️")
+		fatal(ErrNotGitRepo)
+	}
+
+	if cfg.DryRunRepo {
+		if err := runInDryRunRepo(); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if cfg.Insecure {
+		fmt.Fprintln(os.Stderr, "⚠️  --insecure: TLS certificates are NOT being verified. Do not use this outside of testing.")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handleInterrupt(cancel)
+
+	infof("AI provider: %s, Model: %s\n", cfg.Provider, cfg.Model)
+	debugf("config: %+v", cfg.redacted())
+
+	if cfg.Language == "auto" {
+		cfg.Language = detectRepoLanguage()
+	}
+
+	if cfg.Range != "" {
+		if err := validateRange(cfg.Range); err != nil {
+			fatal(err)
+		}
+	}
+
+	switch cfg.Mode {
+	case "commit", "changelog", "reword", "benchmark":
+	case "pr":
+		if err := generatePRDescription(ctx, cfg); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if cfg.Mode == "commit" && cfg.Range == "" && !cfg.EditPassthrough && !cfg.PrepareCommitMsg {
+		if err := checkInProgressOperation(cfg); err != nil {
+			fatal(err)
+		}
+	}
+
+	// git fills in its own template when it runs us as the editor or hook.
+	if cfg.Mode == "commit" && cfg.Template == "" && !cfg.NoCommitTemplate && !cfg.EditPassthrough && !cfg.PrepareCommitMsg {
+		applyCommitTemplate(cfg)
+	}
+	if cfg.Breaking {
+		cfg.SystemPrompt = strings.TrimSpace(cfg.SystemPrompt + " " + breakingInstruction(cfg))
+	}
+	if cfg.AllowedTypes != "" {
+		cfg.SystemPrompt = strings.TrimSpace(cfg.SystemPrompt + " Only use one of these commit types: " + strings.Join(cfg.allowedTypes(), ", ") + ".")
+	}
+
+	if cfg.Mode == "reword" {
+		if err := checkInteractive(cfg); err != nil {
+			fatal(err)
+		}
+		if err := runReword(ctx, cfg); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if cfg.EditPassthrough {
+		if err := runEditPassthrough(ctx, cfg, flag.Args()); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if cfg.PrepareCommitMsg {
+		if err := runPrepareCommitMsg(ctx, cfg, flag.Args()); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if cfg.AddAll || cfg.AddTracked {
+		if err := stageChanges(cfg.AddAll); err != nil {
+			fatal(err)
+		}
+	}
+
+	if cfg.Mode == "commit" && cfg.Range == "" {
+		if err := checkProtectedFiles(cfg); err != nil {
+			fatal(err)
+		}
+		if err := checkPartiallyStaged(cfg); err != nil {
+			fatal(err)
+		}
+	}
+
+	diff, err := getGitDiff(cfg)
+	if err != nil {
+		fatal(err)
+	}
+	if diff == "" && cfg.Range != "" {
+		fatal(fmt.Errorf("%w in %s 🙅", ErrNoChanges, cfg.Range))
+	}
+	if diff == "" && cfg.AllowEmpty {
+		debugf("nothing staged, describing an empty commit")
+		diff = emptyCommitContext()
+	}
+	if diff == "" {
+		fatal(fmt.Errorf("%w to commit 🙅\n%s", ErrNoChanges, noChangesAdvice(cfg)))
+	}
+	if err := checkInteractive(cfg); err != nil {
+		fatal(err)
+	}
+
+	// --mode benchmark checks the size against each of --models instead.
+	if cfg.Mode != "benchmark" {
+		if err := checkDiffSize(ctx, cfg, diff); err != nil {
+			fatal(err)
+		}
+	}
+	warnIfBaseModel(ctx, cfg)
+	if cfg.ScanSecrets {
+		if err := checkSecrets(cfg, diff); err != nil {
+			fatal(err)
+		}
+	}
+	if cfg.PreviewDiff {
+		if err := previewDiff(cfg, diff); err != nil {
+			fatal(err)
+		}
+	}
+
+	if cfg.Mode == "commit" && cfg.Range == "" && cfg.FilterFiles != "" {
+		if err := checkFilteredWorktree(cfg); err != nil {
+			fatal(err)
+		}
+		if left := stagedOutsideFilter(cfg); len(left) > 0 {
+			warnf("%d staged file(s) do not match --filter-files and will stay staged, uncommitted: %s", len(left), strings.Join(left, ", "))
+		}
+	}
+
+	if cfg.Mode == "changelog" {
+		if err := generateChangelogEntry(ctx, cfg, diff); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if cfg.Mode == "benchmark" {
+		if err := runBenchmark(ctx, cfg, diff); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if cfg.Split {
+		if err := runSplit(ctx, cfg); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if cfg.AutoType && cfg.CommitType == "" {
+		cfg.CommitType = detectCommitType(ctx, cfg, diff)
+		debugf("detected commit type: %q", cfg.CommitType)
+	}
+	if err := applyTypeSampling(cfg); err != nil {
+		fatal(err)
+	}
+
+	if cfg.TUI {
+		if isTerminal(os.Stdin) && isTerminal(os.Stdout) {
+			if err := runTUI(ctx, cfg, diff); err != nil {
+				fatal(err)
+			}
+			return
+		}
+		debugf("--tui needs a terminal, using the normal flow")
+	}
+
+	if cfg.List {
+		err := generateListCommits(ctx, cfg, diff)
+		if err != nil {
+			fatal(err)
+		}
+	} else {
+		err := generateSingleCommit(ctx, cfg, diff)
+		if err != nil {
+			fatal(err)
+		}
+	}
+}
+
+// fatal logs err and exits with the code matching its cause. It is the only
+// place the process exits, so everything else returns errors and lets the
+// deferred cleanup run on the way here.
+func fatal(err error) {
+	var childExit dryRunExit
+	switch {
+	case isInterrupted():
+		os.Exit(exitInterrupted)
+	case errors.Is(err, errPromptPrinted), errors.Is(err, errInterrupted), errors.Is(err, errUserAborted), errors.As(err, &childExit):
+		// The prompt was the output, or the user or the child has
+		// already been told.
+	default:
+		errorf("%v", err)
+	}
+	os.Exit(exitCode(err))
+}
+
+// exitCode is the process exit code for err (see exitCodesHelp).
+func exitCode(err error) int {
+	var childExit dryRunExit
+	switch {
+	case errors.Is(err, errPromptPrinted):
+		return 0
+	case errors.Is(err, errInterrupted):
+		return exitInterrupted
+	case errors.As(err, &childExit):
+		return int(childExit)
+	case errors.Is(err, ErrNotGitRepo):
+		return exitNotGitRepo
+	case errors.Is(err, ErrNoChanges):
+		return exitNoChanges
+	case errors.Is(err, ErrAborted):
+		return exitAborted
+	case errors.Is(err, ErrProviderUnavailable):
+		return exitProviderError
+	case errors.Is(err, ErrMessageTooLarge):
+		return exitDiffTooLarge
+	case errors.Is(err, ErrInvalidMessage):
+		return exitInvalidMsg
+	default:
+		return exitError
+	}
+}
+
+// interrupted is closed on the first Ctrl-C or SIGTERM.
+var interrupted = make(chan struct{})
+
+// handleInterrupt aborts the run on Ctrl-C or SIGTERM: it cancels any
+// in-flight request and ends any wait for input, and main unwinds from
+// there through the usual errors, running the deferred cleanup (the index
+// --split rewrote, temp files, the dry-run worktree) before fatal exits. A
+// second signal exits straight away, for when something does not unwind.
+func handleInterrupt(cancel context.CancelFunc) {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		fmt.Fprintln(os.Stderr, "\nAborted 🙅")
+		close(interrupted)
+		cancel()
+		<-sigs
+		fatal(errInterrupted)
+	}()
+}
+
+func isInterrupted() bool {
+	select {
+	case <-interrupted:
+		return true
+	default:
+		return false
+	}
+}
+
+// readLine reads a line from stdin, giving up with errInterrupted on Ctrl-C
+// or SIGTERM; the read itself cannot be cancelled, but the process is about
+// to exit anyway.
+func readLine() (string, error) {
+	type result struct {
+		line string
+		err  error
+	}
+	read := make(chan result, 1)
+	go func() {
+		line, err := stdin.ReadString('\n')
+		read <- result{line, err}
+	}()
+	select {
+	case r := <-read:
+		return r.line, r.err
+	case <-interrupted:
+		return "", errInterrupted
+	}
+}
+
+var spinnerActive atomic.Bool
+
+// isTerminal reports whether f is a character device other than the null
+// device, which is one too but is what CI and < /dev/null give us.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	if null, err := os.Stat(os.DevNull); err == nil && os.SameFile(info, null) {
+		return false
+	}
+	return true
+}
+
+// startSpinner animates message on stderr until the returned function is
+// called. It is a no-op when output is piped, in quiet/verbose mode, or while
+// another spinner is already running (--parallel requests overlap).
+func startSpinner(message string) func() {
+	if quiet || logLevel <= levelDebug || !isTerminal(os.Stdout) || !isTerminal(os.Stderr) {
+		return func() {}
+	}
+	if !spinnerActive.CompareAndSwap(false, true) {
+		return func() {}
+	}
+
+	frames := []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for i := 0; ; i++ {
+			fmt.Fprintf(os.Stderr, "\r%s %s", message, frames[i%len(frames)])
+			select {
+			case <-done:
+				fmt.Fprint(os.Stderr, "\r\033[K")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+			spinnerActive.Store(false)
+		})
+	}
+}
+
+// gitCommand builds a git invocation, pointed at --repo when it is set.
+func gitCommand(args ...string) *exec.Cmd {
+	if repoPath != "" {
+		args = append([]string{"-C", repoPath}, args...)
+	}
+	return exec.Command(gitPath, args...)
+}
+
+func checkGitRepository() bool {
+	cmd := gitCommand("rev-parse", "--is-inside-work-tree")
+	output, err := cmd.Output()
+	if err != nil {
+		return false
+	}
+
+	return strings.TrimSpace(string(output)) == "true"
+}
+
+// stageChanges mirrors git commit -a: all includes untracked files (still
+// honouring .gitignore), otherwise only tracked files are staged.
+func stageChanges(all bool) error {
+	mode := "-u"
+	if all {
+		mode = "-A"
+	}
+	cmd := gitCommand("add", mode)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git add %s failed: %v\n%s", mode, err, output)
+	}
+
+	if logLevel <= levelDebug {
+		output, err := gitCommand("diff", "--staged", "--name-status").Output()
+		if err == nil {
+			debugf("staged changes:\n%s", strings.TrimRight(string(output), "\n"))
+		}
+	}
+	return nil
+}
+
+// isFlagSet reports whether the named flag was passed on the command line,
+// as opposed to holding its default.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// diffSource is what the prompt describes: the staged changes, or --range.
+func diffSource(cfg *Config) []string {
+	if cfg.Range != "" {
+		return []string{cfg.Range}
 	}
+	return []string{"--staged"}
+}
 
-	diff := getGitDiff(*filterFiles)
+func getGitDiff(cfg *Config) (string, error) {
+	diff, err := readGitDiff(cfg, diffSource(cfg))
+	if err != nil {
+		return "", err
+	}
+	if diff == "" && cfg.IgnoreWhitespace {
+		// Nothing but whitespace changed; that is then what to describe.
+		noWhitespaceCfg := *cfg
+		noWhitespaceCfg.IgnoreWhitespace = false
+		if diff, err = readGitDiff(&noWhitespaceCfg, diffSource(cfg)); err != nil {
+			return "", err
+		}
+		if diff != "" {
+			debugf("the changes are whitespace only, sending them as they are")
+		}
+	}
 	if diff == "" {
-		fmt.Println("No changes to commit 🙅")
-		fmt.Println("Maybe you forgot to add the files? Try git add . and then run this script again.")
-		os.Exit(1)
+		return diff, nil
+	}
+	// The diff is sent without its "diff --git" lines, so the file names are
+	// listed separately; the list also survives a truncated diff.
+	if !cfg.NoFileList {
+		files, err := getFileList(cfg)
+		if err != nil {
+			return "", err
+		}
+		if files != "" {
+			diff = "FILES CHANGED:\n" + files + "\n" + diff
+		}
+	}
+	if cfg.Range != "" && cfg.RangeLog {
+		log, err := getRangeLog(cfg.Range)
+		if err != nil {
+			return "", err
+		}
+		diff = "COMMITS IN RANGE:\n" + log + "\n" + diff
+	}
+	if cfg.ContextCommits > 0 {
+		summary, err := getContextLog(cfg)
+		if err != nil {
+			return "", err
+		}
+		if summary != "" {
+			diff = "ALREADY COMMITTED (context only, do not describe these):\n" + summary + "\n\n" + diff
+		}
+	}
+	return diff, nil
+}
+
+// emptyCommitContext stands in for the diff of an --allow-empty commit
+// with nothing staged: the branch and its latest subjects are all there is
+// to say what the commit is for.
+func emptyCommitContext() string {
+	context := "NO CHANGES: this is an empty commit (git commit --allow-empty), for example to trigger CI or mark a point in history. Describe its likely purpose from the branch and recent commits below.\n"
+	if branch, err := gitCommand("symbolic-ref", "--quiet", "--short", "HEAD").Output(); err == nil {
+		context += "BRANCH: " + strings.TrimSpace(string(branch)) + "\n"
+	}
+	if log, err := gitCommand("log", "-5", "--no-color", "--format=- %s").Output(); err == nil && len(log) > 0 {
+		context += "RECENT COMMITS:\n" + strings.TrimRight(string(log), "\n") + "\n"
+	}
+	return context
+}
+
+// getContextLog summarises the --diff-context-commits commits before the
+// changes: those before HEAD, or before the start of --range. It is empty
+// when there is no history yet.
+func getContextLog(cfg *Config) (string, error) {
+	base := "HEAD"
+	if cfg.Range != "" {
+		sep := ".."
+		if strings.Contains(cfg.Range, "...") {
+			sep = "..."
+		}
+		if from, _, _ := strings.Cut(cfg.Range, sep); from != "" {
+			base = from
+		}
+	}
+	if gitCommand("rev-parse", "--verify", "--quiet", base+"^{commit}").Run() != nil {
+		return "", nil
+	}
+	output, err := gitCommand("log", "-n", strconv.Itoa(cfg.ContextCommits), "--no-color", "--format=- %s", "--stat", base).Output()
+	if err != nil {
+		return "", fmt.Errorf("git log failed: %w", err)
 	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+var fileStatusNames = map[byte]string{
+	'A': "added",
+	'C': "copied",
+	'D': "deleted",
+	'M': "modified",
+	'R': "renamed",
+	'T': "type changed",
+}
 
-	if *list {
-		err := generateListCommits(diff, *model, *language, *template, *emoji, *commitType, *maxTokens, *topP, *temperature, *repetitionPenalty, *filterFee)
+// getFileList describes the changed files from git diff --name-status, one
+// "- modified: path" line per file.
+func getFileList(cfg *Config) (string, error) {
+	cmd := gitCommand("diff", "--name-status")
+	cmd.Args = append(cmd.Args, diffSource(cfg)...)
+	if cfg.FilterFiles != "" {
+		cmd.Args = append(cmd.Args, "--", cfg.FilterFiles)
+	}
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git diff --name-status failed: %w", err)
+	}
+
+	var lines []string
+	for _, line := range splitLines(string(output)) {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 || fields[0] == "" {
+			continue
+		}
+		status, ok := fileStatusNames[fields[0][0]]
+		if !ok {
+			status = "changed"
+		}
+		lines = append(lines, "- "+status+": "+strings.Join(fields[1:], " -> "))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// validateRange checks that both ends of a rev..rev or rev...rev range name
+// commits, so a typo fails with a clear message instead of an empty diff.
+func validateRange(revRange string) error {
+	sep := ".."
+	if strings.Contains(revRange, "...") {
+		sep = "..."
+	}
+	from, to, ok := strings.Cut(revRange, sep)
+	if !ok {
+		return fmt.Errorf("invalid --range %q: expected <rev>..<rev>", revRange)
+	}
+
+	for _, rev := range []string{from, to} {
+		if rev == "" {
+			rev = "HEAD"
+		}
+		if gitCommand("rev-parse", "--verify", "--quiet", rev+"^{commit}").Run() != nil {
+			return fmt.Errorf("invalid --range %q: %q is not a known revision", revRange, rev)
+		}
+	}
+	return nil
+}
+
+func getRangeLog(revRange string) (string, error) {
+	output, err := gitCommand("log", "--format=- %s", revRange).Output()
+	if err != nil {
+		return "", fmt.Errorf("git log failed: %w", err)
+	}
+	return strings.TrimRight(string(output), "\n"), nil
+}
+
+// readGitDiff runs git diff with source (e.g. --staged or a revision range)
+// and trims it down to the lines worth sending to the model. Hunk and file
+// headers are dropped unless --keep-hunk-headers or --keep-file-headers ask
+// for them.
+func readGitDiff(cfg *Config, source []string) (string, error) {
+	cmd := gitCommand("diff", "--no-color", "--no-prefix")
+	if cfg.IgnoreWhitespace {
+		cmd.Args = append(cmd.Args, "--ignore-all-space")
+	}
+	cmd.Args = append(cmd.Args, source...)
+	if cfg.FilterFiles != "" {
+		cmd.Args = append(cmd.Args, "--", cfg.FilterFiles)
+	}
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git diff failed: %w", err)
+	}
+	raw := string(output)
+	if len(cfg.Redact) > 0 {
+		raw = redactDiff(raw, cfg.Redact)
+	}
+
+	dmp := diffmatchpatch.New()
+	diffs := dmp.DiffMain(raw, "", true)
+
+	var diffLines []string
+	for _, diff := range diffs {
+		if diff.Type == diffmatchpatch.DiffEqual {
+			continue
+		}
+
+		lines := strings.Split(diff.Text, "\n")
+		for _, line := range lines {
+			if strings.HasPrefix(line, "@@") && !cfg.KeepHunkHeaders {
+				continue
+			}
+			if strings.HasPrefix(line, "diff --git") && !cfg.KeepFileHeaders {
+				continue
+			}
+			if m := binaryDiffRe.FindStringSubmatch(line); m != nil {
+				line = describeBinaryChange(m[1], m[2])
+			}
+			diffLines = append(diffLines, line)
+		}
+	}
+
+	return strings.Join(diffLines, "\n"), nil
+}
+
+// describeBinaryChange turns git's "Binary files x and y differ" into a short
+// note so the model knows the file changed without seeing the noise.
+func describeBinaryChange(from, to string) string {
+	switch {
+	case from == "/dev/null":
+		return "(added binary file: " + to + ")"
+	case to == "/dev/null":
+		return "(deleted binary file: " + from + ")"
+	default:
+		return "(modified binary file: " + to + ")"
+	}
+}
+
+func getChangedFiles(cfg *Config) ([]string, error) {
+	cmd := gitCommand("diff", "--name-only")
+	cmd.Args = append(cmd.Args, diffSource(cfg)...)
+	if cfg.FilterFiles != "" {
+		cmd.Args = append(cmd.Args, "--", cfg.FilterFiles)
+	}
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff --name-only failed: %w", err)
+	}
+
+	return splitLines(string(output)), nil
+}
+
+func splitLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func detectCommitType(ctx context.Context, cfg *Config, diff string) string {
+	allowed := cfg.allowedTypes()
+	files, err := getChangedFiles(cfg)
+	if err != nil {
+		debugf("%v", err)
+	}
+	for _, rule := range autoTypeRules {
+		if rule.matchesAll(files) && slices.Contains(allowed, strings.SplitN(rule.Type, "(", 2)[0]) {
+			return rule.Type
+		}
+	}
+
+	types := allowed
+	if cfg.AllowedTypes == "" {
+		types = make([]string, 0, len(typeToGitmoji))
+		for t := range typeToGitmoji {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+	}
+
+	prompt := "Classify the following git diff with exactly one conventional commit type from this list: " +
+		strings.Join(types, ", ") + ". Reply with the type only.\n" +
+		"START OF GIT DIFF:\n" +
+		diff +
+		"\nEND OF GIT DIFF"
+
+	// The commit-writing system prompt would fight the one-word answer.
+	classifyCfg := *cfg
+	classifyCfg.SystemPrompt = ""
+
+	text, err := sendMessage(ctx, &classifyCfg, prompt)
+	if err != nil {
+		debugf("commit type classification failed: %v", err)
+		return ""
+	}
+
+	answer := strings.ToLower(regexp.MustCompile(`[a-zA-Z]+`).FindString(text))
+	if slices.Contains(types, answer) {
+		return answer
+	}
+	return ""
+}
+
+func (r typeRule) matchesAll(files []string) bool {
+	if len(files) == 0 {
+		return false
+	}
+	for _, file := range files {
+		if !r.matches(file) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r typeRule) matches(file string) bool {
+	for _, pattern := range r.Patterns {
+		if strings.HasSuffix(pattern, "/") {
+			if strings.HasPrefix(file, pattern) || strings.Contains(file, "/"+pattern) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(pattern, file); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(file)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func generateSingleCommit(ctx context.Context, cfg *Config, diff string) error {
+	finalCommitMessage, reused := offerLastMessage(cfg)
+	if !reused {
+		var err error
+		finalCommitMessage, err = generateCommitMessage(ctx, cfg, diff)
+		if err != nil && cfg.givesUpOnTimeout(err) {
+			warnf("the model did not answer within --timeout %s; using a fallback message built from the file list (%v)", cfg.Timeout, err)
+			finalCommitMessage, err = formatCommitMessage(cfg, fallbackMessage(cfg)), nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	renderProposal(cfg, finalCommitMessage)
+
+	if cfg.Explain {
+		printExplanation(ctx, cfg, diff, finalCommitMessage)
+	}
+	copyMessage(cfg, finalCommitMessage)
+	writeOutputFD(cfg, finalCommitMessage)
+
+	if cfg.DryRun {
+		infof("Dry run: nothing was committed\n")
+		return nil
+	}
+	if cfg.Force {
+		return runCommit(cfg, finalCommitMessage)
+	}
+
+	if !confirm(promptOutput(cfg), "Do you want to continue?", cfg.Yes) {
+		return userAborted(promptOutput(cfg), "Commit")
+	}
+
+	return runCommit(cfg, finalCommitMessage)
+}
+
+// printExplanation asks the model why msg fits diff and prints the answer
+// under the proposal. It is shown only; nothing here reaches the commit. With
+// --format plain or json it goes to stderr so stdout stays machine-readable.
+func printExplanation(ctx context.Context, cfg *Config, diff, msg string) {
+	explainCfg := *cfg
+	explainCfg.SystemPrompt = ""
+	text, err := sendMessage(ctx, &explainCfg, getPromptForExplanation(diff, msg, cfg.Language))
+	if err != nil {
+		warnf("could not explain the commit message: %v", err)
+		return
+	}
+
+	out := os.Stdout
+	if cfg.Format != "human" {
+		out = os.Stderr
+	}
+	fmt.Fprintf(out, "Why this message:\n%s\n%s\n", strings.TrimSpace(text), dim(cfg.Separator))
+}
+
+// generateCommitMessage asks the model for one commit message for diff and
+// applies the configured post-processing.
+func generateCommitMessage(ctx context.Context, cfg *Config, diff string) (string, error) {
+	prompt := singleCommitPrompt(cfg, diff)
+
+	// Footers and template sections come after a blank line, which the
+	// default stop would cut.
+	if len(cfg.Stop) == 0 && !cfg.wantsBreakingFooter() && !cfg.followsTemplate {
+		singleCfg := *cfg
+		singleCfg.Stop = stringList{defaultSingleStop}
+		cfg = &singleCfg
+	}
+
+	if !filterAPI(prompt, 1, cfg.FilterFee) {
+		return "", errFeeDeclined
+	}
+
+	text, err := sendMessage(ctx, cfg, prompt)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(text) == "" {
+		return "", errEmptyMessage
+	}
+
+	if check := messageCheck(cfg); check != nil {
+		if text, err = retryUntilValid(ctx, cfg, prompt, text, check); err != nil {
+			return "", err
+		}
+	}
+	if cfg.CheckLanguage != "off" {
+		if text, err = checkMessageLanguage(ctx, cfg, prompt, text); err != nil {
+			return "", err
+		}
+	}
+	if cfg.Refine {
+		if text, err = refineMessage(ctx, cfg, diff, text); err != nil {
+			return "", err
+		}
+	}
+
+	return formatCommitMessage(cfg, text), nil
+}
+
+// strictAttempts is how many generations --strict and --allowed-types try in
+// total before giving up on getting a valid message.
+const strictAttempts = 3
+
+// messageCheck returns the validation raw model output must pass: the full
+// Conventional Commit format with --strict, only the type with
+// --allowed-types, or nil when neither is set.
+func messageCheck(cfg *Config) func(string) error {
+	switch {
+	case cfg.Strict:
+		return func(msg string) error { return validateConventionalCommit(msg, cfg.allowedTypes()) }
+	case cfg.AllowedTypes != "":
+		return func(msg string) error { return checkCommitType(msg, cfg.allowedTypes()) }
+	}
+	return nil
+}
+
+// retryUntilValid regenerates, with a fresh seed and no cache, until the
+// model output passes check. The last invalid message is part of the error
+// so the user can see what was rejected.
+func retryUntilValid(ctx context.Context, cfg *Config, prompt, text string, check func(string) error) (string, error) {
+	retryCfg := *cfg
+	retryCfg.NoCache = true
+	for attempt := 1; ; attempt++ {
+		err := check(text)
+		if err == nil {
+			return text, nil
+		}
+		if attempt == strictAttempts {
+			return "", fmt.Errorf("%w after %d attempts: %v:\n%s", ErrInvalidMessage, attempt, err, strings.TrimSpace(text))
+		}
+		debugf("attempt %d rejected (%v), regenerating", attempt, err)
+
+		if retryCfg.Seed >= 0 {
+			retryCfg.Seed++
+		}
+		if text, err = sendMessage(ctx, &retryCfg, prompt); err != nil {
+			return "", err
+		}
+	}
+}
+
+// maxRegenerations caps "Regenerate" in list mode so a model that keeps
+// missing cannot loop forever.
+const maxRegenerations = 5
+
+// regenerationConfig returns the settings for the next "Regenerate" round:
+// no cache (a hit would hand back the same options), a new seed and a
+// slightly higher temperature, so each round explores further instead of
+// resampling nearly the same options.
+func regenerationConfig(cfg *Config) *Config {
+	regenCfg := *cfg
+	regenCfg.NoCache = true
+	regenCfg.regenerations++
+	regenCfg.Temperature = min(cfg.Temperature+0.15, 2)
+	if cfg.Seed >= 0 {
+		regenCfg.Seed = cfg.Seed + 1
+	}
+	infof("Regenerating (%d of %d, temperature %.2f)...\n", regenCfg.regenerations, maxRegenerations, regenCfg.Temperature)
+	return &regenCfg
+}
+
+// rejectedOption reports whether a raw list option fails check; such options
+// are left out of the menu rather than regenerated.
+func rejectedOption(check func(string) error, option string) bool {
+	if check == nil {
+		return false
+	}
+	if err := check(option); err != nil {
+		debugf("dropping option %q: %v", strings.TrimSpace(option), err)
+		return true
+	}
+	return false
+}
+
+func generateListCommits(ctx context.Context, cfg *Config, diff string) error {
+	numOptions := 5
+
+	var msgs []string
+	if cfg.Parallel {
+		var err error
+		msgs, err = generateOptionsParallel(ctx, cfg, diff, numOptions)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 	} else {
-		err := generateSingleCommit(diff, *model, *language, *template, *emoji, *commitType, *maxTokens, *topP, *temperature, *repetitionPenalty, *force, *filterFee, *filterFiles)
+		prompt := getPromptForListCommits(diff, cfg.CommitType, cfg.Language, numOptions, cfg.Instructions)
+
+		if !filterAPI(prompt, numOptions, cfg.FilterFee) {
+			return errFeeDeclined
+		}
+
+		// --stop is tuned for one message and could end the reply after
+		// the first option, so the combined list request has its own.
+		listCfg := *cfg
+		listCfg.Stop = cfg.ListStop
+		if len(cfg.Stop) > 0 {
+			debugf("--stop does not apply to the list request; using --list-stop %q", cfg.ListStop)
+		}
+		text, err := sendMessage(ctx, &listCfg, prompt)
 		if err != nil {
-			log.Fatal(err)
+			return err
+		}
+
+		for _, option := range strings.Split(text, ";") {
+			if rejectedOption(messageCheck(cfg), option) {
+				continue
+			}
+			msgs = append(msgs, formatCommitMessage(cfg, option))
+		}
+	}
+
+	if messageCheck(cfg) != nil && len(msgs) == 0 {
+		return fmt.Errorf("%w: none of the options qualify", ErrInvalidMessage)
+	}
+
+	distinct := dedupeMessages(msgs)
+	if dropped := len(msgs) - len(distinct); dropped > 0 {
+		debugf("dropped %d duplicate or empty options", dropped)
+	}
+	msgs = distinct
+	if cfg.Backfill && len(msgs) < numOptions {
+		msgs = backfillOptions(ctx, cfg, diff, msgs, numOptions)
+	}
+	if len(msgs) == 0 {
+		return errEmptyMessage
+	}
+
+	for {
+		renderOptions(cfg, msgs)
+		fmt.Fprintf(promptOutput(cfg), "Enter your choice (1-%d), or r<N> to regenerate option N: ", len(msgs)+1)
+		input, err := readLine()
+		input = strings.ToLower(strings.TrimSpace(input))
+		if err != nil && input == "" {
+			if errors.Is(err, errInterrupted) {
+				return err
+			}
+			fmt.Fprintln(os.Stderr)
+			return fmt.Errorf("%w: no choice was entered", ErrAborted)
+		}
+
+		if n, ok := strings.CutPrefix(input, "r"); ok {
+			index, err := strconv.Atoi(n)
+			if err != nil || index < 1 || index > len(msgs) {
+				fmt.Fprintln(promptOutput(cfg), "Invalid option to regenerate.")
+				continue
+			}
+			replacement, err := regenerateListOption(ctx, cfg, diff, msgs, index-1)
+			if err != nil {
+				return err
+			}
+			msgs[index-1] = replacement
+			continue
+		}
+
+		choice, err := strconv.Atoi(input)
+		if err != nil || choice < 1 || choice > len(msgs)+1 {
+			return fmt.Errorf("%w: invalid choice %q", ErrAborted, input)
+		}
+
+		if choice == len(msgs)+1 {
+			if cfg.regenerations >= maxRegenerations {
+				fmt.Fprintf(promptOutput(cfg), "Already regenerated %d times; pick an option or r<N> to regenerate one.\n", cfg.regenerations)
+				continue
+			}
+			return generateListCommits(ctx, regenerationConfig(cfg), diff)
+		}
+
+		copyMessage(cfg, msgs[choice-1])
+		writeOutputFD(cfg, msgs[choice-1])
+		if cfg.DryRun {
+			infof("Dry run: nothing was committed\n")
+			return nil
+		}
+		return runCommit(cfg, msgs[choice-1])
+	}
+}
+
+// generateOptionsParallel makes n independent single-message requests, at
+// most --concurrency at a time, each with its own seed and a slightly higher
+// temperature, and keeps the distinct results. Running them one per request
+// is slower on a single local Ollama (it queues them) but gives more varied
+// options, and remote or batched backends can serve them concurrently.
+func generateOptionsParallel(ctx context.Context, cfg *Config, diff string, n int) ([]string, error) {
+	prompt := singleCommitPrompt(cfg, diff)
+
+	if !filterAPI(prompt, n, cfg.FilterFee) {
+		return nil, errFeeDeclined
+	}
+
+	baseSeed := cfg.Seed
+	if baseSeed < 0 {
+		baseSeed = rand.Intn(1 << 30)
+	}
+
+	start := time.Now()
+	results := make([]string, n)
+	errs := make([]error, n)
+	sem := make(chan struct{}, cfg.Concurrency)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			optionCfg := *cfg
+			optionCfg.Seed = baseSeed + i
+			optionCfg.Temperature = cfg.Temperature + 0.05*float64(i)
+			if len(optionCfg.Stop) == 0 {
+				optionCfg.Stop = stringList{defaultSingleStop}
+			}
+			results[i], errs[i] = sendMessage(ctx, &optionCfg, prompt)
+		}(i)
+	}
+	wg.Wait()
+	debugf("generated %d options in %s with concurrency %d", n, time.Since(start).Round(time.Millisecond), cfg.Concurrency)
+
+	var msgs []string
+	for i, text := range results {
+		if errs[i] != nil {
+			debugf("option %d failed: %v", i+1, errs[i])
+			continue
+		}
+		if rejectedOption(messageCheck(cfg), text) {
+			continue
 		}
+		msgs = append(msgs, formatCommitMessage(cfg, text))
 	}
-}
-
-func checkGitRepository() bool {
-	cmd := exec.Command("git", "rev-parse", "--is-inside-work-tree")
-	output, err := cmd.Output()
-	if err != nil {
-		return false
+	if len(msgs) == 0 {
+		return nil, errs[0]
 	}
 
-	return strings.TrimSpace(string(output)) == "true"
+	return msgs, nil
 }
 
-func getGitDiff(filterFiles string) string {
-    cmd := exec.Command("git", "diff", "--staged" , "--no-color", "--no-prefix")
-		if filterFiles != "" {
-			cmd.Args = append(cmd.Args, filterFiles)
+// backfillOptions asks for replacement options, one at a time, until there
+// are n distinct ones. It gives up after n attempts so a model that keeps
+// repeating itself cannot loop forever, and returns what it has on error.
+func backfillOptions(ctx context.Context, cfg *Config, diff string, msgs []string, n int) []string {
+	for attempt := 0; attempt < n && len(msgs) < n; attempt++ {
+		text, err := sendMessage(ctx, cfg, getPromptForReplacementOption(diff, cfg.CommitType, cfg.Language, msgs, cfg.Instructions))
+		if err != nil {
+			debugf("backfill failed: %v", err)
+			break
 		}
-    output, err := cmd.Output()
-    if err != nil {
-        log.Fatal(err)
-    }
+		msgs = dedupeMessages(append(msgs, formatCommitMessage(cfg, text)))
+	}
+	return msgs
+}
 
-    dmp := diffmatchpatch.New()
-    diffs := dmp.DiffMain(string(output), "", true)
+// regenerateListOption asks the model for a single message to replace
+// msgs[index], steering it away from the options already on screen.
+func regenerateListOption(ctx context.Context, cfg *Config, diff string, msgs []string, index int) (string, error) {
+	prompt := getPromptForReplacementOption(diff, cfg.CommitType, cfg.Language, msgs, cfg.Instructions)
 
-    var diffLines []string
-    for _, diff := range diffs {
-        if diff.Type == diffmatchpatch.DiffEqual {
-            continue
-        }
+	if !filterAPI(prompt, 1, cfg.FilterFee) {
+		return msgs[index], nil
+	}
 
-        lines := strings.Split(diff.Text, "\n")
-        for _, line := range lines {
-            if strings.HasPrefix(line, "@@") || strings.HasPrefix(line, "diff --git") {
-                continue
-            }
-            diffLines = append(diffLines, line)
-        }
-    }
+	text, err := sendMessage(ctx, cfg, prompt)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(text) == "" {
+		warnf("the model returned an empty message, keeping option %d", index+1)
+		return msgs[index], nil
+	}
 
-    return strings.Join(diffLines, "\n")
+	return formatCommitMessage(cfg, text), nil
 }
 
-func generateSingleCommit(diff, model, language, template string, doAddEmoji bool, commitType string, maxTokens, topP, temperature, repetitionPenalty int, force, filterFee bool, filterFiles string) error {
-    diff = getGitDiff(filterFiles)
-
-    if diff == "" {
-        fmt.Println("No changes to commit 🙅")
-        fmt.Println("Maybe you forgot to add the files? Try git add . and then run this script again.")
-        os.Exit(1)
-    }
-
-    prompt := getPromptForSingleCommit(diff, commitType, language)
+func generatePRDescription(ctx context.Context, cfg *Config) error {
+	prCfg := *cfg
+	if prCfg.Range == "" {
+		base := cfg.Base
+		if base == "" {
+			base = defaultBranch()
+		}
+		prCfg.Range = base + "...HEAD"
+	}
 
-	proceed, err := filterAPI(prompt, 1, maxTokens, filterFee)
+	diff, err := getGitDiff(&prCfg)
 	if err != nil {
 		return err
 	}
-	if !proceed {
-		os.Exit(1)
+	if diff == "" {
+		return fmt.Errorf("%w in %s 🙅", ErrNoChanges, prCfg.Range)
 	}
 
-	text, err := sendMessageOllama(prompt, model, maxTokens, topP, temperature, repetitionPenalty)
-	if err != nil {
+	if !isFlagSet("system-prompt") {
+		prCfg.SystemPrompt = defaultPRSystemPrompt
+	}
+	if err := checkDiffSize(ctx, &prCfg, diff); err != nil {
 		return err
 	}
 
-	finalCommitMessage := text
-	if doAddEmoji {
-		finalCommitMessage = addGitmojiToCommitMessage(finalCommitMessage)
-	}
+	prompt := getPromptForPR(diff, cfg.Language, cfg.Instructions)
 
-	if template != "" {
-		finalCommitMessage = processTemplate(template, finalCommitMessage)
-		fmt.Printf("Proposed Commit With Template:\n------------------------------\n%s\n------------------------------\n", finalCommitMessage)
-	} else {
-		fmt.Printf("Proposed Commit:\n------------------------------\n%s\n------------------------------\n", finalCommitMessage)
+	if !filterAPI(prompt, 1, cfg.FilterFee) {
+		return errFeeDeclined
 	}
 
-	if force {
-		makeCommit(finalCommitMessage)
-		return nil
+	text, err := sendMessage(ctx, &prCfg, prompt)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(text) == "" {
+		return errEmptyMessage
 	}
+	text = strings.TrimSpace(text) + "\n"
 
-	fmt.Print("Do you want to continue? (y/n): ")
-	reader := bufio.NewReader(os.Stdin)
-	answer, _ := reader.ReadString('\n')
-	answer = strings.TrimSpace(answer)
-	if strings.ToLower(answer) != "y" {
-		fmt.Println("Commit aborted by user 🙅‍♂️")
-		os.Exit(1)
+	if cfg.Output != "" {
+		if err := os.WriteFile(cfg.Output, []byte(text), 0o644); err != nil {
+			return err
+		}
+		infof("PR description written to %s 📝\n", cfg.Output)
+		return nil
 	}
 
-	makeCommit(finalCommitMessage)
+	fmt.Print(text)
 	return nil
 }
 
-func generateListCommits(diff, model, language, template string, doAddEmoji bool, commitType string, maxTokens, topP, temperature, repetitionPenalty int, filterFee bool) error {
-	numOptions := 5
-	prompt := getPromptForListCommits(diff, commitType, language, numOptions)
-
-	proceed, err := filterAPI(prompt, numOptions, maxTokens, filterFee)
-	if err != nil {
-		return err
-	}
-	if !proceed {
-		os.Exit(1)
-	}
-
-	text, err := sendMessageOllama(prompt, model, maxTokens, topP, temperature, repetitionPenalty)
-	if err != nil {
-		return err
+// defaultBranch guesses the branch a pull request would target: the remote's
+// HEAD if one is configured, otherwise a local main or master.
+func defaultBranch() string {
+	output, err := gitCommand("symbolic-ref", "--quiet", "--short", "refs/remotes/origin/HEAD").Output()
+	if err == nil {
+		return strings.TrimSpace(string(output))
 	}
 
-	msgs := strings.Split(text, ";")
-	for i := range msgs {
-		msgs[i] = strings.TrimSpace(msgs[i])
-		if doAddEmoji {
-			msgs[i] = addGitmojiToCommitMessage(msgs[i])
-		}
-		if template != "" {
-			msgs[i] = processTemplate(template, msgs[i])
+	for _, branch := range []string{"main", "master"} {
+		if gitCommand("rev-parse", "--verify", "--quiet", branch).Run() == nil {
+			return branch
 		}
 	}
+	return "main"
+}
 
-	msgs = append(msgs, regenerateMsg)
+func getPromptForSingleCommit(diff, commitType, language, instructions string) string {
+	prompt := "From the following git diff create a short, useful git commit message in " + language + " language"
 
-	var selectedMsg string
-	fmt.Println("Select a commit message:")
-	for i, msg := range msgs {
-		fmt.Printf("%d. %s\n", i+1, msg)
+	if commitType != "" {
+		prompt += " with commit type '" + commitType + "'. "
+	} else {
+		prompt += ". "
 	}
-	fmt.Print("Enter your choice (1-6): ")
-	var choice int
-	fmt.Scanln(&choice)
 
-	if choice < 1 || choice > len(msgs) {
-		fmt.Println("Invalid choice. Exiting.")
-		os.Exit(1)
-	}
+	prompt += userInstructions(instructions) +
+		"START OF GIT DIFF:\n" +
+		diff +
+		"\nEND OF GIT DIFF"
+
+	return prompt
+}
+
+// smallDiffSubjectLength is the subject length the small-diff prompt asks for.
+const smallDiffSubjectLength = 50
 
-	selectedMsg = msgs[choice-1]
-	if selectedMsg == regenerateMsg {
-		return generateListCommits(diff, model, language, template, doAddEmoji, commitType, maxTokens, topP, temperature, repetitionPenalty, filterFee)
+// singleCommitPrompt picks the prompt for one message: the small-diff one
+// when the changes are under --small-diff-tokens and a one-line message can
+// describe them, the usual one otherwise.
+func singleCommitPrompt(cfg *Config, diff string) string {
+	if n := changedTokens(diff); n < cfg.SmallDiffTokens && !cfg.wantsBreakingFooter() && !cfg.followsTemplate {
+		debugf("the changes are about %d tokens, under --small-diff-tokens %d; using the small-diff prompt", n, cfg.SmallDiffTokens)
+		return getPromptForSmallCommit(diff, cfg.CommitType, cfg.Language, cfg.Instructions)
 	}
+	return getPromptForSingleCommit(diff, cfg.CommitType, cfg.Language, cfg.Instructions)
+}
 
-	makeCommit(selectedMsg)
-	return nil
+// changedTokens estimates the tokens on the lines diff adds or removes,
+// leaving out the file list ahead of the first file, file headers and
+// context lines. A diff with only renames or mode changes counts as small.
+func changedTokens(diff string) int {
+	n, started := 0, false
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++ "):
+			started = true
+		case strings.HasPrefix(line, "--- "):
+		case started && (strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-")):
+			n += estimateTokens(line[1:])
+		}
+	}
+	return n
 }
 
-func getPromptForSingleCommit(diff, commitType, language string) string {
-	prompt := "From the following git diff create a short, useful git commit message in " + language + " language"
+// getPromptForSmallCommit is the stricter prompt for tiny diffs, where the
+// usual one invites models to pad the message with context the diff does
+// not show.
+func getPromptForSmallCommit(diff, commitType, language, instructions string) string {
+	prompt := "The following git diff is a very small change. Summarise exactly what it changes as a single-line git commit message in " + language + " language"
 
 	if commitType != "" {
-		prompt += " with commit type '" + commitType + "'. "
-	} else {
-		prompt += ". "
+		prompt += " with commit type '" + commitType + "'"
 	}
 
-	prompt += "Do not preface the commit with anything, use the present tense, return the full sentence, " +
-		"and use the conventional commits specification (<type in lowercase>: <subject>): " +
+	prompt += ", in under " + strconv.Itoa(smallDiffSubjectLength) + " characters. Describe only what the diff shows; do not guess at reasons or add a body. " +
+		userInstructions(instructions) +
 		"START OF GIT DIFF:\n" +
 		diff +
 		"\nEND OF GIT DIFF"
@@ -256,7 +1988,7 @@ func getPromptForSingleCommit(diff, commitType, language string) string {
 	return prompt
 }
 
-func getPromptForListCommits(diff, commitType, language string, numOptions int) string {
+func getPromptForListCommits(diff, commitType, language string, numOptions int, instructions string) string {
 	prompt := "From the following git diff create a short, useful git commit message in " + language + " language"
 
 	if commitType != "" {
@@ -266,8 +1998,25 @@ func getPromptForListCommits(diff, commitType, language string, numOptions int)
 	}
 
 	prompt += "and make " + fmt.Sprint(numOptions) + " options that are separated by ';'. " +
-		"For each option, use the present tense, return the full sentence, " +
-		"and use the conventional commits specification (<type in lowercase>: <subject>): " +
+		userInstructions(instructions) +
+		"START OF GIT DIFF:\n" +
+		diff +
+		"\nEND OF GIT DIFF"
+
+	return prompt
+}
+
+func getPromptForReplacementOption(diff, commitType, language string, existing []string, instructions string) string {
+	prompt := "From the following git diff create one short, useful git commit message in " + language + " language"
+
+	if commitType != "" {
+		prompt += " with commit type '" + commitType + "'. "
+	} else {
+		prompt += ". "
+	}
+
+	prompt += "It must be different from all of these existing options:\n- " + strings.Join(existing, "\n- ") + "\n" +
+		userInstructions(instructions) +
 		"START OF GIT DIFF:\n" +
 		diff +
 		"\nEND OF GIT DIFF"
@@ -275,14 +2024,52 @@ func getPromptForListCommits(diff, commitType, language string, numOptions int)
 	return prompt
 }
 
+// userInstructions renders --instructions for the prompt, ahead of the diff
+// so the model reads them as instructions rather than as part of the change.
+func userInstructions(instructions string) string {
+	if instructions = strings.TrimSpace(instructions); instructions == "" {
+		return ""
+	}
+	return "Also follow these instructions: " + instructions + "\n"
+}
+
+func breakingInstruction(cfg *Config) string {
+	bang := "Mark it as a breaking change by adding '!' right after the type (or scope), as in 'feat!: <subject>'."
+	footer := "After the subject add a blank line and a footer 'BREAKING CHANGE: <what breaks and how users should migrate>'."
+	switch {
+	case !cfg.wantsBreakingFooter() || cfg.BreakingStyle == "bang":
+		return "This commit is a breaking change. " + bang
+	case cfg.BreakingStyle == "footer":
+		return "This commit is a breaking change. " + footer
+	default:
+		return "This commit is a breaking change. " + bang + " " + footer
+	}
+}
+
+func getPromptForExplanation(diff, msg, language string) string {
+	return "In " + language + " language and in at most three short sentences, explain which changes in the following git diff " +
+		"the commit message '" + msg + "' describes, and point out anything important in the diff it leaves out. " +
+		"START OF GIT DIFF:\n" +
+		diff +
+		"\nEND OF GIT DIFF"
+}
+
+func getPromptForPR(diff, language, instructions string) string {
+	return "From the following git diff write a pull request description in " + language + " language. " +
+		userInstructions(instructions) +
+		"START OF GIT DIFF:\n" +
+		diff +
+		"\nEND OF GIT DIFF"
+}
+
 func processTemplate(template, commitMessage string) string {
 	finalCommitMessage := strings.ReplaceAll(template, "{COMMIT_MESSAGE}", commitMessage)
 
 	if strings.Contains(finalCommitMessage, "{GIT_BRANCH}") {
-		cmd := exec.Command("git", "branch", "--show-current")
+		cmd := gitCommand("branch", "--show-current")
 		output, err := cmd.Output()
 		if err != nil {
-			log.Fatal(err)
+			warnf("could not read the branch name for {GIT_BRANCH}: %v", err)
 		}
 		currentBranch := strings.TrimSpace(string(output))
 		finalCommitMessage = strings.ReplaceAll(finalCommitMessage, "{GIT_BRANCH}", currentBranch)
@@ -291,81 +2078,578 @@ func processTemplate(template, commitMessage string) string {
 	return finalCommitMessage
 }
 
-func sendMessageOllama(prompt, model string, maxTokens, topP, temperature, repetitionPenalty int) (string, error) {
+// sendMessage generates with cfg.Model, switching to --fallback-model
+// if the primary model fails outright (not installed, unreachable, timed out).
+func sendMessage(ctx context.Context, cfg *Config, prompt string) (string, error) {
+	if cfg.PromptOnly {
+		// Exiting here, rather than returning errPromptPrinted, keeps
+		// callers that carry on after a failed request (--auto-type,
+		// --refine, --explain) from printing further prompts. Nothing
+		// needing cleanup can be in progress: --prompt-only is rejected
+		// with --split, --tui and --dry-run-repo.
+		fmt.Print(formatPrompt(cfg, prompt))
+		fatal(errPromptPrinted)
+	}
+	if cfg.ShowPrompt {
+		fmt.Fprint(os.Stderr, formatPrompt(cfg, prompt))
+	}
+
+	text, err := cfg.provider.Generate(ctx, cfg, prompt)
+	if err == nil {
+		debugf("message generated by %s", cfg.Model)
+		return text, nil
+	}
+	if cfg.FallbackModel == "" || ctx.Err() != nil || errors.Is(err, errCostLimit) || cfg.givesUpOnTimeout(err) {
+		return "", fmt.Errorf("%w: %w", ErrProviderUnavailable, err)
+	}
+
+	warnf("model %s failed (%v); falling back to %s", cfg.Model, err, cfg.FallbackModel)
+	fallbackCfg := *cfg
+	fallbackCfg.Model = cfg.FallbackModel
+	fallbackCfg.FallbackModel = ""
+
+	text, err = cfg.provider.Generate(ctx, &fallbackCfg, prompt)
+	if err != nil {
+		return "", fmt.Errorf("%w: fallback model %s also failed: %w", ErrProviderUnavailable, cfg.FallbackModel, err)
+	}
+	infof("Message generated by fallback model %s\n", cfg.FallbackModel)
+	return text, nil
+}
+
+// formatPrompt renders the system prompt and prompt exactly as they will be
+// sent, for --show-prompt and --prompt-only.
+func formatPrompt(cfg *Config, prompt string) string {
+	return "----- system prompt -----\n" + cfg.SystemPrompt + "\n----- prompt -----\n" + prompt + "\n----- end of prompt -----\n"
+}
+
+func generateWithModel(ctx context.Context, cfg *Config, prompt string) (string, error) {
 	data := OllamaRequest{
-		Model:             model,
-		Prompt:            prompt,
-		Stream:            false,
-		MaxTokens:         maxTokens,
-		TopP:              topP,
-		Temperature:       temperature,
-		RepetitionPenalty: repetitionPenalty,
+		Model:  cfg.Model,
+		Prompt: prompt,
+		System: cfg.SystemPrompt,
+		Stream: false,
+		Options: OllamaOptions{
+			TopP:          cfg.TopP,
+			Temperature:   cfg.Temperature,
+			RepeatPenalty: cfg.RepetitionPenalty,
+			NumCtx:        cfg.ContextSize,
+			NumPredict:    cfg.MaxOutputTokens,
+			Stop:          unescapeAll(cfg.Stop),
+			Mirostat:      cfg.Mirostat.ptr(),
+			MirostatTau:   cfg.MirostatTau.ptr(),
+			MirostatEta:   cfg.MirostatEta.ptr(),
+			TopK:          cfg.TopK.ptr(),
+			MinP:          cfg.MinP.ptr(),
+		},
+	}
+	if cfg.Seed >= 0 {
+		data.Options.Seed = &cfg.Seed
 	}
+	data.KeepAlive, _ = parseKeepAlive(cfg.KeepAlive)
 
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return "", err
 	}
 
-	resp, err := http.Post(ollamaURL, contentType, bytes.NewBuffer(jsonData))
+	if cfg.ContextSize > 0 {
+		if n := estimateTokens(cfg.SystemPrompt + " " + prompt); n > cfg.ContextSize {
+			warnf("the prompt is ~%d tokens but --context-size is %d; Ollama will truncate the diff", n, cfg.ContextSize)
+		}
+	}
+
+	if logLevel <= levelDebug {
+		options, _ := json.Marshal(data.Options)
+		debugf("options: %s", options)
+	}
+	debugf("POST %s", cfg.OllamaURL)
+	debugf("system prompt:\n%s", cfg.SystemPrompt)
+	debugf("prompt:\n%s", prompt)
+
+	client, err := newHTTPClient(cfg)
 	if err != nil {
 		return "", err
 	}
+
+	key := cacheKey(jsonData)
+	if !cfg.NoCache {
+		if text, ok := readCache(key, cfg.CacheTTL); ok {
+			debugf("using cached response %s:\n%s", key, text)
+			if cfg.Stats {
+				fmt.Fprintln(os.Stderr, "Stats: served from cache, no tokens generated")
+			}
+			return text, nil
+		}
+	}
+
+	if err := guardRequest(ctx, cfg, prompt); err != nil {
+		return "", err
+	}
+
+	stopSpinner := startSpinner("Generating commit message")
+	defer stopSpinner()
+
+	start := time.Now()
+	delay := cfg.RetryDelay
+	for attempt := 1; ; attempt++ {
+		resp, host, err := postWithFailover(ctx, client, cfg, jsonData)
+		if err == nil {
+			stopSpinner()
+			debugf("served by %s", host)
+			debugf("raw response:\n%s", resp.Response)
+			if cfg.Stats {
+				printStats(cfg.Model, resp, time.Since(start))
+			}
+			if cfg.lastResponse != nil {
+				*cfg.lastResponse = *resp
+			}
+			if resp.DoneReason == "length" {
+				return handleTruncation(ctx, cfg, prompt, resp.Response)
+			}
+			if !cfg.NoCache && strings.TrimSpace(resp.Response) != "" {
+				writeCache(key, resp.Response)
+			}
+			return resp.Response, nil
+		}
+		if attempt > cfg.MaxRetries || !isRetryable(err) || cfg.givesUpOnTimeout(err) {
+			return "", err
+		}
+		debugf("attempt %d failed: %v; retrying in %s", attempt, err, delay)
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// handleTruncation deals with a generation that stopped at the token limit.
+// Interactive users are offered a retry with twice the limit; otherwise the
+// cut-off text is returned with a warning so it is never used silently.
+func handleTruncation(ctx context.Context, cfg *Config, prompt, text string) (string, error) {
+	warnf("the model hit the %d token limit and the message is probably cut off", cfg.MaxOutputTokens)
+	if quiet || !isTerminal(os.Stdin) {
+		return text, nil
+	}
+
+	out := promptOutput(cfg)
+	fmt.Fprintf(out, "Truncated output:\n%s\n", text)
+	if !confirm(out, fmt.Sprintf("Retry with --max-output-tokens %d?", cfg.MaxOutputTokens*2), false) {
+		return text, nil
+	}
+
+	retryCfg := *cfg
+	retryCfg.MaxOutputTokens = cfg.MaxOutputTokens * 2
+	return generateWithModel(ctx, &retryCfg, prompt)
+}
+
+// newHTTPClient builds the client used for model requests. Proxies are taken
+// from HTTP_PROXY, HTTPS_PROXY and NO_PROXY unless --no-proxy is set.
+func newHTTPClient(cfg *Config) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if cfg.NoProxy {
+		transport.Proxy = nil
+	}
+	if len(cfg.ollamaURLs()) > 1 {
+		transport.DialContext = failoverDialer()
+	}
+
+	if cfg.CACert != "" || cfg.Insecure {
+		tlsConfig := &tls.Config{InsecureSkipVerify: cfg.Insecure}
+		if cfg.CACert != "" {
+			pem, err := os.ReadFile(cfg.CACert)
+			if err != nil {
+				return nil, fmt.Errorf("reading --ca-cert: %w", err)
+			}
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", cfg.CACert)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   cfg.Timeout,
+	}, nil
+}
+
+func postOllama(ctx context.Context, client *http.Client, cfg *Config, endpoint string, jsonData []byte) (*OllamaResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if cfg.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.AuthToken)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
 	defer resp.Body.Close()
 
 	var ollamaResp OllamaResponse
 	err = json.NewDecoder(resp.Body).Decode(&ollamaResp)
+	if resp.StatusCode >= 400 {
+		return nil, &httpStatusError{StatusCode: resp.StatusCode, Message: ollamaResp.Error}
+	}
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	return &ollamaResp, nil
+}
+
+// printStats reports Ollama's token counters for one generation on stderr,
+// so they never end up mixed into the message on stdout.
+func printStats(model string, resp *OllamaResponse, wall time.Duration) {
+	tokensPerSec := 0.0
+	if resp.EvalDuration > 0 {
+		tokensPerSec = float64(resp.EvalCount) / time.Duration(resp.EvalDuration).Seconds()
+	}
+	fmt.Fprintf(os.Stderr, "Stats (%s): prompt tokens %d, generated tokens %d, %.1f tokens/sec, model time %s, wall time %s\n",
+		model, resp.PromptEvalCount, resp.EvalCount, tokensPerSec,
+		time.Duration(resp.TotalDuration).Round(time.Millisecond), wall.Round(time.Millisecond))
+}
+
+// isRetryable reports whether err is a transport failure or a server-side
+// error. Client errors such as an unknown model are returned immediately.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return true
+	}
+
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+
+	return false
+}
+
+// runCommit commits commitMessage. With --filter-files only the matching
+// paths are committed, so the commit holds exactly what the message was
+// written from; other staged files stay staged. Nothing is committed once
+// Ctrl-C has been pressed, whatever was still on its way here.
+func runCommit(cfg *Config, commitMessage string) error {
+	if isInterrupted() {
+		return errInterrupted
+	}
+	if cfg.Signoff {
+		signed, err := signOff(commitMessage)
+		if err != nil {
+			return err
+		}
+		commitMessage = signed
+	}
+	if cfg.CommitCommand != "" {
+		infof("Committing Message... 🚀\n")
+		output, err := runCommitCommand(cfg, commitMessage)
+		return finishCommit(commitMessage, "--commit-command", output, err)
+	}
+	args := []string{"commit", "-m", commitMessage}
+	if cfg.AllowEmpty {
+		args = append(args, "--allow-empty")
+	}
+	var date string
+	if cfg.Date != "" {
+		date, _ = parseCommitDate(cfg.Date)
+		args = append(args, "--date", date)
+	}
+	if cfg.FilterFiles != "" {
+		args = append(args, "--", cfg.FilterFiles)
+	}
+
+	infof("Committing Message... 🚀\n")
+	cmd := gitCommand(args...)
+	if date != "" {
+		// --date only sets the author date.
+		cmd.Env = append(os.Environ(), "GIT_COMMITTER_DATE="+date)
+	}
+	output, err := cmd.CombinedOutput()
+	return finishCommit(commitMessage, "git commit", output, err)
+}
+
+// finishCommit reports how the commit went, keeping the message for the
+// next run when it failed.
+func finishCommit(commitMessage, command string, output []byte, err error) error {
+	if err != nil {
+		err = fmt.Errorf("%s failed (%v)", command, err)
+		if output := strings.TrimSpace(string(output)); output != "" {
+			err = fmt.Errorf("%w:\n%s", err, output)
+		}
+		if path, saveErr := saveLastMessage(commitMessage); saveErr == nil {
+			err = fmt.Errorf("%w\nThe message was saved to %s and will be offered on the next run", err, path)
+		}
+		return err
+	}
+	removeLastMessage()
+	debugf("%s output:\n%s", command, strings.TrimSpace(string(output)))
+	infof("Commit Successful! 🎉\n")
+	return nil
+}
+
+// checkFilteredWorktree refuses --filter-files commits when the matching
+// files also have unstaged edits: "git commit -- <paths>" takes the working
+// tree copy of the paths, not the index, so those edits would be committed
+// under a message written from the staged diff alone.
+func checkFilteredWorktree(cfg *Config) error {
+	output, err := gitCommand("diff", "--name-only", "--", cfg.FilterFiles).Output()
+	if err != nil {
+		return err
+	}
+	if unstaged := splitLines(string(output)); len(unstaged) > 0 {
+		return fmt.Errorf("files matching --filter-files have unstaged changes that the commit would include: %s (stage or stash them first)", strings.Join(unstaged, ", "))
+	}
+	return nil
+}
+
+// checkPartiallyStaged warns about staged files that were edited again
+// after staging, since the message will describe the staged version rather
+// than the one on disk. At a terminal it offers to stage the edits too.
+func checkPartiallyStaged(cfg *Config) error {
+	staged, err := gitCommand("diff", "--staged", "--name-only").Output()
+	if err != nil {
+		debugf("listing staged files failed: %v", err)
+		return nil
+	}
+	unstaged, err := gitCommand("diff", "--name-only").Output()
+	if err != nil {
+		debugf("listing unstaged files failed: %v", err)
+		return nil
+	}
+
+	edited := map[string]bool{}
+	for _, file := range splitLines(string(unstaged)) {
+		edited[file] = true
+	}
+	var both []string
+	for _, file := range splitLines(string(staged)) {
+		if edited[file] {
+			both = append(both, file)
+		}
+	}
+	if len(both) == 0 {
+		return nil
+	}
+
+	warnf("%d staged file(s) have changes that are not staged: %s", len(both), strings.Join(both, ", "))
+	if quiet || cfg.Force || !isTerminal(os.Stdin) {
+		return nil
 	}
 
-	return ollamaResp.Response, nil
+	if !confirm(promptOutput(cfg), "Stage those changes too?", false) {
+		return nil
+	}
+	cmd := gitCommand(append([]string{"add", "--"}, both...)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git add failed (%v):\n%s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
 }
 
-func makeCommit(commitMessage string) {
-	fmt.Println("Committing Message... 🚀")
-	cmd := exec.Command("git", "commit", "-m", commitMessage)
-	err := cmd.Run()
+// stagedOutsideFilter lists staged files that --filter-files excludes from
+// both the prompt and the commit.
+func stagedOutsideFilter(cfg *Config) []string {
+	output, err := gitCommand("diff", "--staged", "--name-only").Output()
 	if err != nil {
-		log.Fatal(err)
+		debugf("listing staged files failed: %v", err)
+		return nil
+	}
+
+	files, err := getChangedFiles(cfg)
+	if err != nil {
+		debugf("%v", err)
+		return nil
+	}
+	matched := map[string]bool{}
+	for _, file := range files {
+		matched[file] = true
+	}
+
+	var left []string
+	for _, file := range splitLines(string(output)) {
+		if !matched[file] {
+			left = append(left, file)
+		}
+	}
+	return left
+}
+
+// acceptDefaults is set by checkInteractive when stdin has nothing to read
+// and --yes was given, so confirm takes the default at end of input.
+var acceptDefaults bool
+
+// checkInteractive fails before the model is called when committing would
+// need an answer stdin can never give: it is not a terminal and is already
+// at end of input, as in CI or with < /dev/null. Piped answers still work,
+// and --force, --dry-run and the hook modes never ask. With --yes the
+// confirmation prompts take their default instead.
+func checkInteractive(cfg *Config) error {
+	asks := (cfg.Mode == "commit" || (cfg.Mode == "reword" && cfg.Range == "")) && !cfg.Force && !cfg.DryRun && !cfg.PromptOnly && !cfg.EditPassthrough && !cfg.PrepareCommitMsg
+	if !asks || isTerminal(os.Stdin) {
+		return nil
+	}
+	if _, err := stdin.Peek(1); err == nil {
+		return nil
 	}
-	fmt.Println("Commit Successful! 🎉")
+	if cfg.List {
+		return errors.New("stdin is not a terminal and has no input, so no option can be chosen; pipe the choice in (echo 1 | llamapusher --list), or drop --list and use --force or --yes")
+	}
+	if cfg.Yes {
+		acceptDefaults = true
+		return nil
+	}
+	return errors.New("stdin is not a terminal and has no input, so the confirmation prompt cannot be answered; use --force or --yes to commit without asking, or --dry-run to only print the message")
 }
 
-func filterAPI(prompt string, numCompletion, maxTokens int, filterFee bool) (bool, error) {
-	numTokens := len(strings.Fields(prompt))
-	fee := float64(numTokens)/1000*0.02 + (0.001 * float64(numCompletion))
+// confirm asks a yes/no question on stdin. An empty answer takes the
+// default, shown in capitals in the prompt; anything but y/yes/n/no, in any
+// case, counts as no. End of input without an answer is no, so a closed
+// stdin never commits, unless checkInteractive set acceptDefaults.
+func confirm(out io.Writer, question string, defaultYes bool) bool {
+	hint := "(y/N)"
+	if defaultYes {
+		hint = "(Y/n)"
+	}
+	fmt.Fprintf(out, "%s %s: ", question, hint)
+
+	answer, err := readLine()
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	case "":
+		return defaultYes && (err == nil || acceptDefaults)
+	default:
+		return false
+	}
+}
 
-	if numTokens > maxTokens {
-		fmt.Printf("The commit diff is too large. Max %d tokens allowed.\n", maxTokens)
-		return false, nil
+// userAborted tells the user what was aborted after they said no at a
+// prompt, unless a Ctrl-C is why, and returns errUserAborted.
+func userAborted(out io.Writer, what string) error {
+	if !isInterrupted() {
+		fmt.Fprintln(out, what+" aborted by user 🙅‍♂️")
 	}
+	return errUserAborted
+}
+
+// filterAPI asks for confirmation of the approximate fee with --filter-fee.
+// The diff size is checked once up front by checkDiffSize. The question goes
+// to infoOutput, which is stderr whenever stdout is reserved for the result.
+func filterAPI(prompt string, numCompletion int, filterFee bool) bool {
+	fee := estimateFee(prompt, numCompletion)
+
+	debugf("prompt tokens (estimated): %d", estimateTokens(prompt))
 
 	if filterFee {
-		fmt.Printf("This will cost you ~$%.3f for using the API.\n", fee)
-		fmt.Print("Do you want to continue 💸? (y/n): ")
-		reader := bufio.NewReader(os.Stdin)
-		answer, _ := reader.ReadString('\n')
-		answer = strings.TrimSpace(answer)
-		if strings.ToLower(answer) != "y" {
-			return false, nil
+		fmt.Fprintf(infoOutput, "This will cost you ~$%.3f for using the API.\n", fee)
+		if !confirm(infoOutput, "Do you want to continue 💸?", false) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// commitDateLayouts are the --date formats accepted, tried in order.
+var commitDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	time.RFC1123Z,
+	"Mon Jan 2 15:04:05 2006 -0700",
+}
+
+// parseCommitDate checks --date and returns it in the ISO 8601 form git
+// reads the same way everywhere. Dates without a zone are local time.
+func parseCommitDate(value string) (string, error) {
+	for _, layout := range commitDateLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t.Format(time.RFC3339), nil
+		}
+	}
+	return "", fmt.Errorf("invalid --date %q: use a date like 2024-05-01, 2024-05-01 14:30 or 2024-05-01T14:30:00+02:00", value)
+}
+
+// parseKeepAlive converts --keep-alive into the form Ollama expects: a bare
+// number of seconds (negative keeps the model loaded) or a duration string.
+func parseKeepAlive(value string) (any, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return seconds, nil
+	}
+	if _, err := time.ParseDuration(value); err != nil {
+		return nil, fmt.Errorf("invalid --keep-alive %q: use a duration like 10m or a number of seconds", value)
+	}
+	return value, nil
+}
+
+// unescapeAll interprets Go escape sequences such as \n so stop sequences can
+// be passed on the command line. Values that fail to unquote are kept as-is.
+func unescapeAll(values []string) []string {
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if unquoted, err := strconv.Unquote(`"` + v + `"`); err == nil {
+			v = unquoted
 		}
+		out = append(out, v)
 	}
+	return out
+}
 
-	return true, nil
+// estimateTokens gives a rough token count by counting whitespace-separated words.
+func estimateTokens(text string) int {
+	return len(strings.Fields(text))
 }
 
-func addGitmojiToCommitMessage(commitMessage string) string {
+// addGitmojiToCommitMessage picks the gitmoji from the message's own type,
+// falling back to commitType when the model did not lead with a known type,
+// and places it according to position (prefix, after-type or suffix). style
+// picks the unicode emoji or its :shortcode:.
+func addGitmojiToCommitMessage(commitMessage, commitType, position, style string) string {
+	table := typeToGitmoji
+	if style == "shortcode" {
+		table = typeToShortcode
+	}
+
 	re := regexp.MustCompile(`\b[a-zA-Z]+\b`)
 	match := re.FindString(commitMessage)
 
-	if match == "" {
+	gitmoji, ok := table[match]
+	if !ok {
+		gitmoji, ok = table[re.FindString(commitType)]
+	}
+	if !ok || commitMessage == "" {
 		return commitMessage
 	}
 
-	if gitmoji, ok := typeToGitmoji[match]; ok {
+	prefix, subject, rest := splitSubject(commitMessage)
+	switch {
+	case position == "after-type" && prefix != "":
+		return joinSubject(prefix, gitmoji+" "+subject, rest)
+	case position == "suffix":
+		return joinSubject(prefix, subject+" "+gitmoji, rest)
+	default:
 		return gitmoji + " " + commitMessage
 	}
-
-	return commitMessage
 }
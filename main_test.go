@@ -0,0 +1,990 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// runMainEnv makes the test binary run main instead of the tests, so runCLI
+// can exercise the whole command without building it separately.
+const runMainEnv = "RUN_LLAMAPUSHER_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// testRepo creates an empty repository for the test, with its own HOME so
+// neither the user's git config nor their config file and cache apply, and
+// points gitCommand at it until the test ends.
+func testRepo(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	home := t.TempDir()
+	for key, value := range map[string]string{
+		"HOME":                home,
+		"XDG_CONFIG_HOME":     filepath.Join(home, ".config"),
+		"XDG_CACHE_HOME":      filepath.Join(home, ".cache"),
+		"GIT_CONFIG_NOSYSTEM": "1",
+		"GIT_AUTHOR_NAME":     "Test",
+		"GIT_AUTHOR_EMAIL":    "test@example.com",
+		"GIT_COMMITTER_NAME":  "Test",
+		"GIT_COMMITTER_EMAIL": "test@example.com",
+	} {
+		t.Setenv(key, value)
+	}
+
+	previous := repoPath
+	repoPath = dir
+	t.Cleanup(func() { repoPath = previous })
+
+	git(t, "init", "-q")
+	return dir
+}
+
+// git runs a git command in the test repository and returns its trimmed
+// output, failing the test if it fails.
+func git(t *testing.T, args ...string) string {
+	t.Helper()
+	output, err := gitCommand(args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// writeTestFile writes content to name under dir, creating directories.
+func writeTestFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// stageFile writes content to name in the repository at dir and stages it.
+func stageFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	writeTestFile(t, dir, name, content)
+	git(t, "add", "--", name)
+}
+
+// runCLI runs llamapusher with args in dir, with input on stdin, and returns
+// what it printed and its exit code. LLAMAPUSHER_* variables only come from
+// env, so the developer's own settings cannot change the result.
+func runCLI(t *testing.T, dir, input string, env []string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(executable, args...)
+	cmd.Dir = dir
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envPrefix) {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	cmd.Env = append(cmd.Env, runMainEnv+"=1", "NO_COLOR=1")
+	cmd.Env = append(cmd.Env, env...)
+	cmd.Stdin = strings.NewReader(input)
+	var out, errOut bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+
+	var exitErr *exec.ExitError
+	if err := cmd.Run(); err != nil && !errors.As(err, &exitErr) {
+		t.Fatal(err)
+	}
+	return out.String(), errOut.String(), cmd.ProcessState.ExitCode()
+}
+
+// sampleDiff is a small staged change as getGitDiff returns it.
+const sampleDiff = "FILES CHANGED:\n- modified: main.go\n--- main.go\n+++ main.go\n-func old() {}\n+func renamed() {}"
+
+// testConfig is a Config with the defaults main gives the flags, except
+// that it has no gitmoji, no cache and the mock provider.
+func testConfig() *Config {
+	return &Config{
+		Mode:              "commit",
+		Provider:          "mock",
+		provider:          mockProvider{},
+		OllamaURL:         defaultOllamaURL,
+		Model:             "tinydolphin:1.1b-v2.8-q5_K_M",
+		Language:          "english",
+		CheckLanguage:     "off",
+		SystemPrompt:      defaultSystemPrompt,
+		TopP:              1,
+		Temperature:       1,
+		RepetitionPenalty: 1,
+		MaxOutputTokens:   2048,
+		Concurrency:       2,
+		MaxRetries:        3,
+		Seed:              -1,
+		NoCache:           true,
+		CacheTTL:          24 * time.Hour,
+		RetryDelay:        time.Millisecond,
+		SubjectCase:       "preserve",
+		EmojiPosition:     "prefix",
+		GitmojiStyle:      "unicode",
+		BreakingStyle:     "both",
+		Format:            "human",
+		Separator:         defaultSeparator,
+	}
+}
+
+// ollamaStub starts a fake Ollama answering each generate request with the
+// next of responses, repeating the last one, and returns testConfig pointed
+// at it together with the generate requests it has received. Other
+// endpoints, such as /api/show, are not found.
+func ollamaStub(t *testing.T, responses ...string) (*Config, func() []OllamaRequest) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []OllamaRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/api/generate") {
+			http.NotFound(w, r)
+			return
+		}
+		var req OllamaRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		requests = append(requests, req)
+		response := responses[min(len(requests), len(responses))-1]
+		mu.Unlock()
+		json.NewEncoder(w).Encode(OllamaResponse{Response: response, Done: true})
+	}))
+	t.Cleanup(server.Close)
+
+	cfg := testConfig()
+	cfg.Provider, cfg.provider = "ollama", ollamaProvider{}
+	cfg.OllamaURL = server.URL + "/api/generate"
+	return cfg, func() []OllamaRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(requests)
+	}
+}
+
+func TestDescribeBinaryChange(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     string
+	}{
+		{"/dev/null", "logo.png", "(added binary file: logo.png)"},
+		{"logo.png", "/dev/null", "(deleted binary file: logo.png)"},
+		{"logo.png", "logo.png", "(modified binary file: logo.png)"},
+	}
+	for _, tt := range tests {
+		if got := describeBinaryChange(tt.from, tt.to); got != tt.want {
+			t.Errorf("describeBinaryChange(%q, %q) = %q, want %q", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestGetGitDiffBinaryFiles(t *testing.T) {
+	dir := testRepo(t)
+	stageFile(t, dir, "old.bin", "\x00\x01old")
+	stageFile(t, dir, "changed.bin", "\x00\x01before")
+	git(t, "commit", "-q", "-m", "init")
+
+	stageFile(t, dir, "logo.png", "\x89PNG\x00\x00")
+	stageFile(t, dir, "changed.bin", "\x00\x01after")
+	git(t, "rm", "-q", "old.bin")
+	stageFile(t, dir, "main.go", "package main\n")
+
+	diff, err := getGitDiff(&Config{NoFileList: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"(added binary file: logo.png)",
+		"(modified binary file: changed.bin)",
+		"(deleted binary file: old.bin)",
+		"+package main",
+	} {
+		if !strings.Contains(diff, want) {
+			t.Errorf("diff does not contain %q:\n%s", want, diff)
+		}
+	}
+	if strings.Contains(diff, "Binary files") {
+		t.Errorf("diff still has git's binary lines:\n%s", diff)
+	}
+}
+
+func TestStopSequencesInRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		stop stringList
+		want []string
+	}{
+		{"none", nil, nil},
+		{"escaped newlines", stringList{`\n\n`}, []string{"\n\n"}},
+		{"several", stringList{`\n\n`, "END"}, []string{"\n\n", "END"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, requests := ollamaStub(t, "feat: add stop sequences")
+			cfg.Stop = tt.stop
+			if _, err := generateWithModel(context.Background(), cfg, "prompt"); err != nil {
+				t.Fatal(err)
+			}
+			if got := requests()[0].Options.Stop; !slices.Equal(got, tt.want) {
+				t.Errorf("options.stop = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSingleCommitStop(t *testing.T) {
+	tests := []struct {
+		name     string
+		stop     stringList
+		breaking bool
+		want     []string
+	}{
+		{"default", nil, false, []string{"\n\n"}},
+		{"--stop replaces the default", stringList{"END"}, false, []string{"END"}},
+		{"no default with a breaking footer", nil, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, requests := ollamaStub(t, "feat: add stop sequences")
+			cfg.Stop, cfg.Breaking = tt.stop, tt.breaking
+			if _, err := generateCommitMessage(context.Background(), cfg, sampleDiff); err != nil {
+				t.Fatal(err)
+			}
+			if got := requests()[0].Options.Stop; !slices.Equal(got, tt.want) {
+				t.Errorf("options.stop = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRepoFlag runs from a directory outside the repository, as editor
+// integrations do, and commits through --repo.
+func TestRepoFlag(t *testing.T) {
+	dir := testRepo(t)
+	stageFile(t, dir, "README.md", "readme\n")
+	git(t, "commit", "-q", "-m", "init")
+	worktree := filepath.Join(t.TempDir(), "worktree")
+	git(t, "worktree", "add", "-q", "-b", "side", worktree)
+
+	tests := []struct {
+		name string
+		repo string
+		path string
+	}{
+		{"repository", dir, "main.go"},
+		{"subdirectory", filepath.Join(dir, "docs"), "docs/guide.md"},
+		{"linked worktree", worktree, "side.go"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.MkdirAll(tt.repo, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			repoPath = tt.repo
+			repoPath = git(t, "rev-parse", "--show-toplevel")
+			stageFile(t, repoPath, tt.path, "content\n")
+
+			_, stderr, code := runCLI(t, t.TempDir(), "", nil, "--repo", tt.repo, "--provider", "mock", "--no-emoji", "--force")
+			if code != 0 {
+				t.Fatalf("exit code %d, stderr:\n%s", code, stderr)
+			}
+			if got, want := git(t, "log", "-1", "--format=%s"), "chore: update "+tt.path; got != want {
+				t.Errorf("committed %q in %s, want %q", got, tt.repo, want)
+			}
+		})
+	}
+}
+
+func TestRunCommitFailureOutput(t *testing.T) {
+	tests := []struct {
+		name          string
+		hook          string
+		stage         bool
+		commitCommand string
+		want          []string
+	}{
+		{
+			name:  "failing pre-commit hook",
+			hook:  "#!/bin/sh\necho 'lint failed: main.go' >&2\nexit 1\n",
+			stage: true,
+			want:  []string{"git commit failed (exit status 1)", "lint failed: main.go"},
+		},
+		{
+			name: "nothing staged",
+			want: []string{"git commit failed (exit status 1)", "nothing to commit"},
+		},
+		{
+			name:          "failing --commit-command",
+			stage:         true,
+			commitCommand: "echo 'jj: no repo here' >&2; exit 2",
+			want:          []string{"--commit-command failed (exit status 2)", "jj: no repo here"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := testRepo(t)
+			stageFile(t, dir, "README.md", "readme\n")
+			git(t, "commit", "-q", "-m", "init")
+			if tt.hook != "" {
+				if err := os.WriteFile(filepath.Join(dir, ".git", "hooks", "pre-commit"), []byte(tt.hook), 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.stage {
+				stageFile(t, dir, "main.go", "package main\n")
+			}
+
+			cfg := testConfig()
+			cfg.CommitCommand = tt.commitCommand
+			err := runCommit(cfg, "feat: add main")
+			if err == nil {
+				t.Fatal("runCommit succeeded")
+			}
+			for _, want := range append(tt.want, lastMessageFile) {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error does not mention %q:\n%v", want, err)
+				}
+			}
+			if saved, _ := os.ReadFile(filepath.Join(dir, ".git", lastMessageFile)); string(saved) != "feat: add main\n" {
+				t.Errorf("saved message = %q, want the rejected message", saved)
+			}
+		})
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   []string
+	}{
+		{"defaults", func(*Config) {}, nil},
+		{"list with force", func(c *Config) { c.List, c.Force = true, true }, []string{"--list and --force cannot be used together"}},
+		{"split with list", func(c *Config) { c.Split, c.List = true, true }, []string{"--split cannot be used with --list or --force"}},
+		{"tui with dry run", func(c *Config) { c.TUI, c.DryRun = true, true }, []string{"--tui is its own interactive commit flow"}},
+		{"message only with list", func(c *Config) { c.MessageOnly, c.List = true, true }, []string{"--message-only prints a single message"}},
+		{"add all with add tracked", func(c *Config) { c.AddAll, c.AddTracked = true, true }, []string{"--add-all and --add-tracked cannot be used together"}},
+		{"list outside commit mode", func(c *Config) { c.Mode, c.List = "pr", true }, []string{"--list only applies to --mode commit"}},
+		{"fallback on timeout without timeout", func(c *Config) { c.FallbackOnTimeout = true }, []string{"--fallback-on-timeout needs a --timeout"}},
+		{
+			"first line only with a breaking footer",
+			func(c *Config) { c.FirstLineOnly, c.Breaking, c.BreakingStyle = true, true, "footer" },
+			[]string{"--first-line-only cannot be used with --breaking-style footer"},
+		},
+		{"negative temperature", func(c *Config) { c.Temperature = -0.1 }, []string{"--temperature must be >= 0"}},
+		{"top-p above 1", func(c *Config) { c.TopP = 1.5 }, []string{"--top-p must be between 0 and 1"}},
+		{"no output tokens", func(c *Config) { c.MaxOutputTokens = 0 }, []string{"--max-output-tokens must be >= 1"}},
+		{"no concurrency", func(c *Config) { c.Concurrency = 0 }, []string{"--concurrency must be >= 1"}},
+		{"seed below -1", func(c *Config) { c.Seed = -2 }, []string{"--seed must be >= 0"}},
+		{"unknown mode", func(c *Config) { c.Mode = "squash" }, []string{`unknown --mode "squash"`}},
+		{"unknown format", func(c *Config) { c.Format = "yaml" }, []string{"--format must be human, plain or json"}},
+		{"unknown subject case", func(c *Config) { c.SubjectCase = "upper" }, []string{"--subject-case must be lower, sentence or preserve"}},
+		{"ollama url without scheme", func(c *Config) { c.OllamaURL = "localhost:11434" }, []string{`--ollama-url: "localhost:11434" is not an http(s) URL`}},
+		{"allowed types that list none", func(c *Config) { c.AllowedTypes = " , " }, []string{"--allowed-types lists no types"}},
+		{"invalid protect pattern", func(c *Config) { c.Protect = stringList{"[a"} }, []string{`invalid --protect pattern "[a"`}},
+		{
+			"every problem is reported",
+			func(c *Config) { c.Temperature, c.Concurrency = -1, 0 },
+			[]string{"--temperature must be >= 0", "--concurrency must be >= 1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			tt.modify(cfg)
+			err := validateConfig(cfg)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("validateConfig() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("validateConfig() succeeded, want %q", tt.want)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("validateConfig() = %v, want it to mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+// TestFlagConflictExitCode checks that a conflict stops the command before
+// anything runs, with the generic exit code.
+func TestFlagConflictExitCode(t *testing.T) {
+	dir := testRepo(t)
+	stageFile(t, dir, "main.go", "package main\n")
+
+	_, stderr, code := runCLI(t, dir, "", nil, "--provider", "mock", "--list", "--force", "--temperature", "-1")
+	if code != exitError {
+		t.Errorf("exit code %d, want %d", code, exitError)
+	}
+	for _, want := range []string{"--list and --force", "--temperature must be >= 0"} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("stderr does not mention %q:\n%s", want, stderr)
+		}
+	}
+	if git(t, "rev-list", "--all") != "" {
+		t.Error("a commit was made")
+	}
+}
+
+// TestFilterFilesPartialCommit checks that --filter-files commits only the
+// matching files and leaves the other staged files staged.
+func TestFilterFilesPartialCommit(t *testing.T) {
+	tests := []struct {
+		filter        string
+		wantCommitted []string
+		wantStaged    []string
+	}{
+		{"*.go", []string{"main.go"}, []string{"docs/guide.md"}},
+		{"docs", []string{"docs/guide.md"}, []string{"main.go"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			dir := testRepo(t)
+			stageFile(t, dir, "README.md", "readme\n")
+			git(t, "commit", "-q", "-m", "init")
+			stageFile(t, dir, "main.go", "package main\n")
+			stageFile(t, dir, "docs/guide.md", "guide\n")
+
+			_, stderr, code := runCLI(t, dir, "", nil, "--provider", "mock", "--force", "--filter-files", tt.filter)
+			if code != 0 {
+				t.Fatalf("exit code %d, stderr:\n%s", code, stderr)
+			}
+			if got := splitLines(git(t, "show", "--format=", "--name-only", "HEAD")); !slices.Equal(got, tt.wantCommitted) {
+				t.Errorf("committed %q, want %q", got, tt.wantCommitted)
+			}
+			if got := splitLines(git(t, "diff", "--staged", "--name-only")); !slices.Equal(got, tt.wantStaged) {
+				t.Errorf("still staged %q, want %q", got, tt.wantStaged)
+			}
+		})
+	}
+}
+
+// TestFilterFilesMessageMatchesCommit is the regression test for messages
+// written from the filtered diff while the whole index was committed.
+func TestFilterFilesMessageMatchesCommit(t *testing.T) {
+	dir := testRepo(t)
+	stageFile(t, dir, "README.md", "readme\n")
+	git(t, "commit", "-q", "-m", "init")
+	stageFile(t, dir, "a.txt", "outside the filter\n")
+	stageFile(t, dir, "main.go", "package main\n")
+
+	_, stderr, code := runCLI(t, dir, "", nil, "--provider", "mock", "--no-emoji", "--force", "--filter-files", "*.go")
+	if code != 0 {
+		t.Fatalf("exit code %d, stderr:\n%s", code, stderr)
+	}
+	if got, want := git(t, "log", "-1", "--format=%s"), "chore: update main.go"; got != want {
+		t.Errorf("message %q, want %q: it should be written from the filtered diff", got, want)
+	}
+	if got := git(t, "show", "--format=", "--name-only", "HEAD"); got != "main.go" {
+		t.Errorf("committed %q, want only main.go, which the message describes", got)
+	}
+	if want := "1 staged file(s) do not match --filter-files and will stay staged, uncommitted: a.txt"; !strings.Contains(stderr, want) {
+		t.Errorf("stderr does not warn %q:\n%s", want, stderr)
+	}
+}
+
+// TestFilterFilesUnstagedEdits checks that the commit is refused when it
+// would take matching files' unstaged edits, which the message never saw.
+func TestFilterFilesUnstagedEdits(t *testing.T) {
+	dir := testRepo(t)
+	stageFile(t, dir, "main.go", "package main\n")
+	git(t, "commit", "-q", "-m", "init")
+	stageFile(t, dir, "main.go", "package main\n\nfunc a() {}\n")
+	writeTestFile(t, dir, "main.go", "package main\n\nfunc b() {}\n")
+
+	_, stderr, code := runCLI(t, dir, "", nil, "--provider", "mock", "--force", "--filter-files", "*.go")
+	if code != exitError {
+		t.Errorf("exit code %d, want %d", code, exitError)
+	}
+	if want := "files matching --filter-files have unstaged changes that the commit would include: main.go"; !strings.Contains(stderr, want) {
+		t.Errorf("stderr does not mention %q:\n%s", want, stderr)
+	}
+	if got := git(t, "rev-list", "--count", "HEAD"); got != "1" {
+		t.Errorf("%s commits, want only the initial one", got)
+	}
+}
+
+func TestEmptyModelResponse(t *testing.T) {
+	for _, response := range []string{"", "  \n\t\n"} {
+		cfg, _ := ollamaStub(t, response)
+		_, err := generateCommitMessage(context.Background(), cfg, sampleDiff)
+		if !errors.Is(err, errEmptyMessage) || !errors.Is(err, ErrProviderUnavailable) {
+			t.Errorf("generateCommitMessage() with response %q = %v, want errEmptyMessage", response, err)
+		}
+	}
+}
+
+// TestEmptyModelResponseIsNotCommitted checks both flows end with a clear
+// error and no commit when the model says nothing.
+func TestEmptyModelResponseIsNotCommitted(t *testing.T) {
+	for _, args := range [][]string{{"--force"}, {"--list"}} {
+		t.Run(args[0], func(t *testing.T) {
+			dir := testRepo(t)
+			stageFile(t, dir, "main.go", "package main\n")
+
+			_, stderr, code := runCLI(t, dir, "1\n", []string{"LLAMAPUSHER_MOCK_RESPONSE= "}, append([]string{"--provider", "mock"}, args...)...)
+			if code != exitProviderError {
+				t.Errorf("exit code %d, want %d", code, exitProviderError)
+			}
+			if want := "the model returned an empty message"; !strings.Contains(stderr, want) {
+				t.Errorf("stderr does not mention %q:\n%s", want, stderr)
+			}
+			if git(t, "rev-list", "--all") != "" {
+				t.Error("a commit was made")
+			}
+		})
+	}
+}
+
+func TestGetGitDiffIgnoreWhitespace(t *testing.T) {
+	const original = "func a() {\nreturn 1\n}\n"
+	tests := []struct {
+		name             string
+		content          string
+		ignoreWhitespace bool
+		want, notWant    []string
+	}{
+		{
+			name:    "whitespace kept",
+			content: "func a() {\n\treturn 1\n}\nfunc b() {}\n",
+			want:    []string{"+\treturn 1", "+func b() {}"},
+		},
+		{
+			name:             "whitespace dropped",
+			content:          "func a() {\n\treturn 1\n}\nfunc b() {}\n",
+			ignoreWhitespace: true,
+			want:             []string{"+func b() {}"},
+			notWant:          []string{"-return 1", "+\treturn 1"},
+		},
+		{
+			name:             "reformat only falls back to the full diff",
+			content:          "func a() {\n\treturn 1\n}\n",
+			ignoreWhitespace: true,
+			want:             []string{"-return 1", "+\treturn 1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := testRepo(t)
+			stageFile(t, dir, "a.go", original)
+			git(t, "commit", "-q", "-m", "init")
+			stageFile(t, dir, "a.go", tt.content)
+
+			diff, err := getGitDiff(&Config{NoFileList: true, IgnoreWhitespace: tt.ignoreWhitespace})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(diff, want) {
+					t.Errorf("diff does not contain %q:\n%s", want, diff)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(diff, notWant) {
+					t.Errorf("diff contains %q:\n%s", notWant, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestInstructionsInPrompts(t *testing.T) {
+	const instructions = "mention ticket ABC-123"
+	prompts := map[string]string{
+		"single":      getPromptForSingleCommit(sampleDiff, "", "english", instructions),
+		"small":       getPromptForSmallCommit(sampleDiff, "", "english", instructions),
+		"list":        getPromptForListCommits(sampleDiff, "", "english", 5, instructions),
+		"replacement": getPromptForReplacementOption(sampleDiff, "", "english", []string{"feat: a"}, instructions),
+		"pr":          getPromptForPR(sampleDiff, "english", instructions),
+		"changelog":   getPromptForChangelog(sampleDiff, "english", instructions),
+	}
+	for name, prompt := range prompts {
+		at := strings.Index(prompt, "Also follow these instructions: "+instructions+"\n")
+		if at < 0 {
+			t.Errorf("%s prompt does not contain the instructions:\n%s", name, prompt)
+			continue
+		}
+		if diff := strings.Index(prompt, sampleDiff); diff < at {
+			t.Errorf("%s prompt has the instructions after the diff:\n%s", name, prompt)
+		}
+	}
+
+	for _, empty := range []string{"", "  \n"} {
+		if got := userInstructions(empty); got != "" {
+			t.Errorf("userInstructions(%q) = %q, want nothing", empty, got)
+		}
+	}
+}
+
+// TestInstructionsKeepSystemPrompt checks -i adds to the prompt without
+// replacing --system-prompt.
+func TestInstructionsKeepSystemPrompt(t *testing.T) {
+	dir := testRepo(t)
+	stageFile(t, dir, "main.go", "package main\n")
+
+	stdout, stderr, code := runCLI(t, dir, "", nil, "--provider", "mock", "--prompt-only",
+		"--system-prompt", "You write terse commit messages.", "-i", "mention ticket ABC-123")
+	if code != 0 {
+		t.Fatalf("exit code %d, stderr:\n%s", code, stderr)
+	}
+	for _, want := range []string{"----- system prompt -----\nYou write terse commit messages.\n", "Also follow these instructions: mention ticket ABC-123\n"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("the prompt does not contain %q:\n%s", want, stdout)
+		}
+	}
+}
+
+// TestListModeStops runs --list against a fake Ollama that, like the real
+// one, cuts the reply at the first stop sequence, and checks that every
+// option comes through even when --stop is set for single messages.
+func TestListModeStops(t *testing.T) {
+	const reply = "feat: add foo;\n\nfix: handle bar;\n\ndocs: explain baz END and some rambling"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req OllamaRequest
+		if !strings.HasSuffix(r.URL.Path, "/api/generate") || json.NewDecoder(r.Body).Decode(&req) != nil {
+			http.NotFound(w, r)
+			return
+		}
+		response := reply
+		for _, stop := range req.Options.Stop {
+			response, _, _ = strings.Cut(response, stop)
+		}
+		json.NewEncoder(w).Encode(OllamaResponse{Response: response, Done: true})
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"--stop does not apply", []string{"--stop", `\n\n`}, []string{"feat: add foo", "fix: handle bar", "docs: explain baz END and some rambling"}},
+		{"--list-stop does", []string{"--stop", `\n\n`, "--list-stop", " END"}, []string{"feat: add foo", "fix: handle bar", "docs: explain baz"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := testRepo(t)
+			stageFile(t, dir, "foo.go", "package foo\n")
+
+			args := append([]string{"--ollama-url", server.URL + "/api/generate", "--no-emoji", "--no-cache", "--list", "--dry-run", "--format", "json"}, tt.args...)
+			stdout, stderr, code := runCLI(t, dir, "1\n", nil, args...)
+			if code != 0 {
+				t.Fatalf("exit code %d, stderr:\n%s", code, stderr)
+			}
+			var menu struct{ Options []string }
+			if err := json.NewDecoder(strings.NewReader(stdout)).Decode(&menu); err != nil {
+				t.Fatalf("decoding %q: %v", stdout, err)
+			}
+			if !slices.Equal(menu.Options, tt.want) {
+				t.Errorf("options = %q, want %q", menu.Options, tt.want)
+			}
+		})
+	}
+}
+
+// TestNonInteractiveStdin feeds the prompts a closed or piped, non-terminal
+// stdin, as in CI.
+func TestNonInteractiveStdin(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		args       []string
+		wantCode   int
+		wantStderr string
+		wantCommit bool
+	}{
+		{"closed stdin", "", nil, exitError, "use --force or --yes to commit without asking", false},
+		{"closed stdin with --list", "", []string{"--list"}, exitError, "pipe the choice in", false},
+		{"closed stdin with --yes", "", []string{"--yes"}, 0, "", true},
+		{"closed stdin with --force", "", []string{"--force"}, 0, "", true},
+		{"closed stdin with --dry-run", "", []string{"--dry-run"}, 0, "", false},
+		{"piped yes", "y\n", nil, 0, "", true},
+		{"piped no", "n\n", nil, exitAborted, "", false},
+		{"piped choice", "1\n", []string{"--list"}, 0, "", true},
+		{"piped invalid choice", "9\n", []string{"--list"}, exitAborted, `invalid choice "9"`, false},
+		{"piped blank line", "\n", []string{"--list"}, exitAborted, `invalid choice ""`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := testRepo(t)
+			stageFile(t, dir, "main.go", "package main\n")
+
+			_, stderr, code := runCLI(t, dir, tt.input, nil, append([]string{"--provider", "mock"}, tt.args...)...)
+			if code != tt.wantCode {
+				t.Errorf("exit code %d, want %d; stderr:\n%s", code, tt.wantCode, stderr)
+			}
+			if !strings.Contains(stderr, tt.wantStderr) {
+				t.Errorf("stderr does not mention %q:\n%s", tt.wantStderr, stderr)
+			}
+			if committed := git(t, "rev-list", "--all") != ""; committed != tt.wantCommit {
+				t.Errorf("committed %v, want %v", committed, tt.wantCommit)
+			}
+		})
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{fmt.Errorf("%w 🙅", ErrNotGitRepo), exitNotGitRepo},
+		{fmt.Errorf("%w to commit", ErrNoChanges), exitNoChanges},
+		{fmt.Errorf("%w: invalid choice", ErrAborted), exitAborted},
+		{errUserAborted, exitAborted},
+		{errFeeDeclined, exitAborted},
+		{errCostLimit, exitAborted},
+		{fmt.Errorf("%w: connection refused", ErrProviderUnavailable), exitProviderError},
+		{errEmptyMessage, exitProviderError},
+		{fmt.Errorf("%w: ~9000 tokens", ErrMessageTooLarge), exitDiffTooLarge},
+		{fmt.Errorf("%w after 3 attempts", ErrInvalidMessage), exitInvalidMsg},
+		{errInterrupted, exitInterrupted},
+		{errPromptPrinted, 0},
+		{fmt.Errorf("dry run: %w", dryRunExit(9)), 9},
+		{errors.New("git diff failed"), exitError},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+// TestSentinelErrors checks the error each cause is reported with, for
+// callers using errors.Is.
+func TestSentinelErrors(t *testing.T) {
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name string
+		run  func() error
+		want error
+	}{
+		{"provider unavailable", func() error {
+			cfg := testConfig()
+			cfg.Provider, cfg.provider, cfg.OllamaURL, cfg.MaxRetries = "ollama", ollamaProvider{}, closed.URL+"/api/generate", 0
+			_, err := sendMessage(context.Background(), cfg, "prompt")
+			return err
+		}, ErrProviderUnavailable},
+		{"message too large", func() error {
+			cfg := testConfig()
+			cfg.MaxInputTokens = 3
+			return checkDiffSize(context.Background(), cfg, sampleDiff)
+		}, ErrMessageTooLarge},
+		{"invalid message", func() error {
+			cfg, _ := ollamaStub(t, "not a conventional commit")
+			cfg.Strict = true
+			_, err := generateCommitMessage(context.Background(), cfg, sampleDiff)
+			return err
+		}, ErrInvalidMessage},
+		{"aborted", func() error { return userAborted(io.Discard, "Commit") }, ErrAborted},
+	}
+	for _, tt := range tests {
+		if err := tt.run(); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+// TestSentinelExitCodes runs one scenario per cause and checks the exit
+// code it ends with.
+func TestSentinelExitCodes(t *testing.T) {
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name     string
+		noRepo   bool
+		noStage  bool
+		input    string
+		env      []string
+		args     []string
+		wantCode int
+	}{
+		{name: "not a git repository", noRepo: true, args: []string{"--force"}, wantCode: exitNotGitRepo},
+		{name: "no changes", noStage: true, args: []string{"--force"}, wantCode: exitNoChanges},
+		{name: "aborted", input: "n\n", wantCode: exitAborted},
+		{name: "provider unavailable", args: []string{"--provider", "ollama", "--ollama-url", closed.URL + "/api/generate", "--max-retries", "0", "--force"}, wantCode: exitProviderError},
+		{name: "message too large", args: []string{"--max-input-tokens", "1", "--force"}, wantCode: exitDiffTooLarge},
+		{name: "invalid message", env: []string{"LLAMAPUSHER_MOCK_RESPONSE=Updated some files"}, args: []string{"--strict", "--force"}, wantCode: exitInvalidMsg},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := testRepo(t)
+			env := tt.env
+			if tt.noRepo {
+				dir = t.TempDir()
+				env = append(env, "GIT_CEILING_DIRECTORIES="+filepath.Dir(dir))
+			} else if !tt.noStage {
+				stageFile(t, dir, "main.go", "package main\n")
+			}
+
+			_, stderr, code := runCLI(t, dir, tt.input, env, append([]string{"--provider", "mock"}, tt.args...)...)
+			if code != tt.wantCode {
+				t.Errorf("exit code %d, want %d; stderr:\n%s", code, tt.wantCode, stderr)
+			}
+		})
+	}
+}
+
+func TestChangedTokens(t *testing.T) {
+	tests := []struct {
+		diff string
+		want int
+	}{
+		{sampleDiff, 6},
+		{"FILES CHANGED:\n- modified: a.go\n--- a.go\n+++ a.go\n context line\n+x := 1", 3},
+		{"FILES CHANGED:\n- renamed: a.go -> b.go\nsimilarity index 100%", 0},
+		{"- in the file list\n+++ a.go\n+one two", 2},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := changedTokens(tt.diff); got != tt.want {
+			t.Errorf("changedTokens(%q) = %d, want %d", tt.diff, got, tt.want)
+		}
+	}
+}
+
+func TestSingleCommitPromptSelection(t *testing.T) {
+	largeDiff := "--- main.go\n+++ main.go\n" + strings.Repeat("+fmt.Println(\"a line of real change\")\n", 20)
+	tests := []struct {
+		name      string
+		diff      string
+		modify    func(*Config)
+		wantSmall bool
+	}{
+		{"small diff", sampleDiff, func(*Config) {}, true},
+		{"large diff", largeDiff, func(*Config) {}, false},
+		{"at the threshold", sampleDiff, func(c *Config) { c.SmallDiffTokens = 6 }, false},
+		{"just under the threshold", sampleDiff, func(c *Config) { c.SmallDiffTokens = 7 }, true},
+		{"raised threshold", largeDiff, func(c *Config) { c.SmallDiffTokens = 1000 }, true},
+		{"turned off", sampleDiff, func(c *Config) { c.SmallDiffTokens = 0 }, false},
+		{"breaking footer wanted", sampleDiff, func(c *Config) { c.Breaking = true }, false},
+		{"commit template", sampleDiff, func(c *Config) { c.followsTemplate = true }, false},
+	}
+	for _, tt := range tests {
+		cfg := testConfig()
+		cfg.SmallDiffTokens = 40
+		tt.modify(cfg)
+		prompt := singleCommitPrompt(cfg, tt.diff)
+		want := getPromptForSingleCommit(tt.diff, cfg.CommitType, cfg.Language, cfg.Instructions)
+		if tt.wantSmall {
+			want = getPromptForSmallCommit(tt.diff, cfg.CommitType, cfg.Language, cfg.Instructions)
+		}
+		if prompt != want {
+			t.Errorf("%s: got the wrong prompt:\n%s", tt.name, prompt)
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection refused", &url.Error{Op: "Post", URL: defaultOllamaURL, Err: errors.New("connection refused")}, true},
+		{"wrapped connection error", fmt.Errorf("generate: %w", &url.Error{Op: "Post", URL: defaultOllamaURL, Err: io.EOF}), true},
+		{"server error", &httpStatusError{StatusCode: http.StatusServiceUnavailable}, true},
+		{"internal error", &httpStatusError{StatusCode: http.StatusInternalServerError, Message: "model failed to load"}, true},
+		{"client error", &httpStatusError{StatusCode: http.StatusNotFound, Message: "model not found"}, false},
+		{"interrupted", context.Canceled, false},
+		{"interrupted mid-request", &url.Error{Op: "Post", URL: defaultOllamaURL, Err: context.Canceled}, false},
+		{"other error", errors.New("bad response"), false},
+	}
+	for _, tt := range tests {
+		if got := isRetryable(tt.err); got != tt.want {
+			t.Errorf("%s: isRetryable(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+// TestGenerateRetries points generateWithModel at a server that fails a
+// number of times before answering.
+func TestGenerateRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		failures     int
+		maxRetries   int
+		wantRequests int
+		wantErr      bool
+	}{
+		{name: "recovers", status: http.StatusServiceUnavailable, failures: 2, maxRetries: 3, wantRequests: 3},
+		{name: "out of retries", status: http.StatusServiceUnavailable, failures: 5, maxRetries: 2, wantRequests: 3, wantErr: true},
+		{name: "retries off", status: http.StatusBadGateway, failures: 1, maxRetries: 0, wantRequests: 1, wantErr: true},
+		{name: "client error", status: http.StatusNotFound, failures: 1, maxRetries: 3, wantRequests: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !strings.HasSuffix(r.URL.Path, "/api/generate") {
+					http.NotFound(w, r)
+					return
+				}
+				mu.Lock()
+				requests++
+				failing := requests <= tt.failures
+				mu.Unlock()
+				if failing {
+					w.WriteHeader(tt.status)
+					json.NewEncoder(w).Encode(OllamaResponse{Error: "try again"})
+					return
+				}
+				json.NewEncoder(w).Encode(OllamaResponse{Response: "fix: retry", Done: true})
+			}))
+			defer server.Close()
+
+			cfg := testConfig()
+			cfg.Provider, cfg.provider = "ollama", ollamaProvider{}
+			cfg.OllamaURL = server.URL + "/api/generate"
+			cfg.MaxRetries = tt.maxRetries
+			text, err := generateWithModel(context.Background(), cfg, "prompt")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && text != "fix: retry" {
+				t.Errorf("response %q, want %q", text, "fix: retry")
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("%d requests, want %d", requests, tt.wantRequests)
+			}
+		})
+	}
+}
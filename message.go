@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// conventionalHeaderRe splits a "type(scope)!: subject" line into the
+// prefix up to and including the colon and the subject that follows.
+var conventionalHeaderRe = regexp.MustCompile(`^([a-zA-Z]+(?:\([^)]*\))?!?:)\s*(.*)$`)
+
+// conventionalTypes are the types --strict accepts, from the Conventional
+// Commits spec and the Angular convention commitlint defaults to.
+var conventionalTypes = []string{"feat", "fix", "docs", "style", "refactor", "perf", "test", "build", "ci", "chore", "revert"}
+
+// normalizeCommitType lower-cases --commit-type and expands a typeAliases
+// name, keeping any scope: "Feature(api)" becomes "feat(api)".
+func normalizeCommitType(commitType string) string {
+	name, scope, hasScope := strings.Cut(commitType, "(")
+	name = strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := typeAliases[name]; ok {
+		name = alias
+	}
+	if hasScope {
+		return name + "(" + scope
+	}
+	return name
+}
+
+// strictHeaderRe is the full Conventional Commit header: a lowercase type,
+// an optional non-empty scope, an optional "!", a colon, a space and the
+// subject.
+var strictHeaderRe = regexp.MustCompile(`^([a-z]+)(\([^()\s][^()]*\))?!?: (\S.*)$`)
+
+// validateConventionalCommit checks raw model output, before gitmoji or a
+// template are applied, against the Conventional Commits header format with
+// a type from types.
+func validateConventionalCommit(msg string, types []string) error {
+	header, _, _ := strings.Cut(strings.TrimSpace(msg), "\n")
+	if !strictHeaderRe.MatchString(strings.TrimSpace(header)) {
+		return fmt.Errorf("%q does not match <type>(<scope>): <subject>", header)
+	}
+	return checkCommitType(msg, types)
+}
+
+// checkCommitType only fails when msg has a conventional header whose type
+// is not in types; a message without a header passes.
+func checkCommitType(msg string, types []string) error {
+	header, _, _ := strings.Cut(strings.TrimSpace(msg), "\n")
+	m := conventionalHeaderRe.FindStringSubmatch(strings.TrimSpace(header))
+	if m == nil {
+		return nil
+	}
+	commitType := strings.ToLower(regexp.MustCompile(`^[a-zA-Z]+`).FindString(m[1]))
+	if !slices.Contains(types, commitType) {
+		return fmt.Errorf("type %q is not allowed (expected one of %s)", commitType, strings.Join(types, ", "))
+	}
+	return nil
+}
+
+// formatCommitMessage applies the configured post-processing to raw model
+// output, in the order: clean-up, subject normalisation, gitmoji, template.
+func formatCommitMessage(cfg *Config, msg string) string {
+	msg = strings.TrimSpace(msg)
+	if cfg.FirstLineOnly {
+		msg = firstLine(msg)
+	}
+	if cfg.Breaking {
+		msg = markBreaking(msg, cfg.BreakingStyle == "footer" && !cfg.List, cfg.wantsBreakingFooter())
+	}
+	msg = applySubjectCase(msg, cfg.SubjectCase)
+	if !cfg.KeepPeriod {
+		msg = stripSubjectPeriod(msg)
+	}
+	if cfg.Emoji {
+		msg = addGitmojiToCommitMessage(msg, cfg.CommitType, cfg.EmojiPosition, cfg.GitmojiStyle)
+	}
+	if cfg.Template != "" {
+		msg = processTemplate(cfg.Template, msg)
+	}
+	return msg
+}
+
+// firstLine returns the first non-empty line of msg, for models that follow
+// a good subject with explanations nobody asked for.
+func firstLine(msg string) string {
+	for _, line := range strings.Split(msg, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// breakingFooterRe matches the footer Conventional Commits uses to describe
+// a breaking change; "BREAKING-CHANGE" is an accepted synonym.
+var breakingFooterRe = regexp.MustCompile(`(?m)^BREAKING[ -]CHANGE: \S`)
+
+// markBreaking makes a --breaking message consistent with itself. The "!"
+// is added unless only the footer was asked for; if the footer was asked
+// for but the model left it out, the "!" is added regardless so the commit
+// is still marked, since a footer cannot be made up after the fact.
+func markBreaking(msg string, footerOnly, wantFooter bool) string {
+	hasFooter := breakingFooterRe.MatchString(msg)
+	if wantFooter && !hasFooter {
+		warnf("the model did not write a BREAKING CHANGE footer; marking the type with '!' instead")
+	}
+	if footerOnly && hasFooter {
+		return msg
+	}
+
+	prefix, subject, rest := splitSubject(msg)
+	if prefix == "" || strings.HasSuffix(prefix, "!:") {
+		return msg
+	}
+	return joinSubject(strings.TrimSuffix(prefix, ":")+"!:", subject, rest)
+}
+
+// splitSubject separates the first line of msg into the conventional-commit
+// prefix (empty if the line has none) and the subject. rest keeps everything
+// from the first newline on, so joinSubject can put the message back as-is.
+func splitSubject(msg string) (prefix, subject, rest string) {
+	line := msg
+	if i := strings.IndexByte(msg, '\n'); i >= 0 {
+		line, rest = msg[:i], msg[i:]
+	}
+	if m := conventionalHeaderRe.FindStringSubmatch(line); m != nil {
+		return m[1], m[2], rest
+	}
+	return "", line, rest
+}
+
+func joinSubject(prefix, subject, rest string) string {
+	if prefix == "" {
+		return subject + rest
+	}
+	return prefix + " " + subject + rest
+}
+
+// applySubjectCase changes the case of the subject's first letter. Words
+// that look like names or identifiers (APIs, GitHub, camelCase, v1.2) are
+// left alone, as is everything after the first word.
+func applySubjectCase(msg, mode string) string {
+	if mode == "preserve" || mode == "" {
+		return msg
+	}
+
+	prefix, subject, rest := splitSubject(msg)
+	if subject == "" {
+		return msg
+	}
+
+	first, tail, hasTail := strings.Cut(subject, " ")
+	r, size := utf8.DecodeRuneInString(first)
+	if !unicode.IsLetter(r) {
+		return msg
+	}
+
+	switch mode {
+	case "lower":
+		if !isPlainCapitalised(first) {
+			return msg
+		}
+		first = string(unicode.ToLower(r)) + first[size:]
+	case "sentence":
+		first = string(unicode.ToUpper(r)) + first[size:]
+	}
+
+	subject = first
+	if hasTail {
+		subject += " " + tail
+	}
+	return joinSubject(prefix, subject, rest)
+}
+
+// isPlainCapitalised reports whether word is an ordinary capitalised word
+// ("Add") rather than an acronym ("API") or a mixed-case name ("GitHub").
+func isPlainCapitalised(word string) bool {
+	for i, r := range word {
+		if i == 0 {
+			if !unicode.IsUpper(r) {
+				return false
+			}
+			continue
+		}
+		if unicode.IsUpper(r) || unicode.IsDigit(r) || strings.ContainsRune("._-/`", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// stripSubjectPeriod drops a single trailing "." from the subject line. An
+// ellipsis is kept, and the body is never touched.
+func stripSubjectPeriod(msg string) string {
+	prefix, subject, rest := splitSubject(msg)
+	trimmed := strings.TrimRight(subject, " ")
+	if !strings.HasSuffix(trimmed, ".") || strings.HasSuffix(trimmed, "..") {
+		return msg
+	}
+	return joinSubject(prefix, strings.TrimSuffix(trimmed, "."), rest)
+}
+
+// dedupeMessages drops empty messages and those that only differ from an
+// earlier one in case, punctuation, emoji or spacing, keeping the first
+// occurrence.
+func dedupeMessages(msgs []string) []string {
+	seen := map[string]bool{}
+	var distinct []string
+	for _, msg := range msgs {
+		key := normaliseForComparison(msg)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		distinct = append(distinct, msg)
+	}
+	return distinct
+}
+
+func normaliseForComparison(msg string) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(strings.ToLower(msg), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(word)
+	}
+	return b.String()
+}
+
+// signOff adds the "Signed-off-by:" trailer git commit -s would, with the
+// committer identity, using git interpret-trailers so it lands after the
+// body and any other trailers.
+func signOff(msg string) (string, error) {
+	ident, err := gitCommand("var", "GIT_COMMITTER_IDENT").Output()
+	if err != nil {
+		return "", fmt.Errorf("cannot sign off: git has no committer identity (set user.name and user.email): %w", err)
+	}
+	// The identity ends in a timestamp and time zone.
+	fields := strings.Fields(string(ident))
+	if len(fields) > 2 {
+		fields = fields[:len(fields)-2]
+	}
+
+	cmd := gitCommand("interpret-trailers", "--if-exists", "addIfDifferent", "--trailer", "Signed-off-by: "+strings.Join(fields, " "))
+	cmd.Stdin = strings.NewReader(msg + "\n")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git interpret-trailers failed: %w", err)
+	}
+	return strings.TrimRight(string(output), "\n"), nil
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestApplySubjectCase(t *testing.T) {
+	tests := []struct {
+		mode string
+		msg  string
+		want string
+	}{
+		{"preserve", "feat: Add login", "feat: Add login"},
+		{"lower", "feat: Add login", "feat: add login"},
+		{"lower", "feat(auth)!: Add login\n\nBody Stays", "feat(auth)!: add login\n\nBody Stays"},
+		{"lower", "Add login", "add login"},
+		{"lower", "fix: API keys leak", "fix: API keys leak"},
+		{"lower", "docs: GitHub actions", "docs: GitHub actions"},
+		{"lower", "chore: Go.mod tidy", "chore: Go.mod tidy"},
+		{"lower", "feat: V2 endpoint", "feat: V2 endpoint"},
+		{"lower", "fix: 404 page", "fix: 404 page"},
+		{"sentence", "feat: add login", "feat: Add login"},
+		{"sentence", "feat: add Login Page", "feat: Add Login Page"},
+		{"sentence", "feat: ✨ add login", "feat: ✨ add login"},
+		{"sentence", "feat:", "feat:"},
+	}
+	for _, tt := range tests {
+		if got := applySubjectCase(tt.msg, tt.mode); got != tt.want {
+			t.Errorf("applySubjectCase(%q, %s) = %q, want %q", tt.msg, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestStripSubjectPeriod(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{"feat: add login.", "feat: add login"},
+		{"feat: add login. ", "feat: add login"},
+		{"add login.", "add login"},
+		{"feat: add login", "feat: add login"},
+		{"feat: wait for it...", "feat: wait for it..."},
+		{"feat: add v1.2", "feat: add v1.2"},
+		{"feat: add login.\n\nIt works. Really.", "feat: add login\n\nIt works. Really."},
+		{"feat: add login\n\nBody ends here.", "feat: add login\n\nBody ends here."},
+		{"feat: add login..", "feat: add login.."},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := stripSubjectPeriod(tt.msg); got != tt.want {
+			t.Errorf("stripSubjectPeriod(%q) = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestKeepPeriod(t *testing.T) {
+	cfg := testConfig()
+	for keep, want := range map[bool]string{false: "fix: handle errors", true: "fix: handle errors."} {
+		cfg.KeepPeriod = keep
+		if got := formatCommitMessage(cfg, "fix: handle errors."); got != want {
+			t.Errorf("formatCommitMessage with KeepPeriod %v = %q, want %q", keep, got, want)
+		}
+	}
+}
+
+func TestAddGitmojiPosition(t *testing.T) {
+	tests := []struct {
+		position   string
+		msg        string
+		commitType string
+		want       string
+	}{
+		{"prefix", "feat: add foo", "", "✨ feat: add foo"},
+		{"after-type", "feat: add foo", "", "feat: ✨ add foo"},
+		{"after-type", "fix(api)!: drop v1\n\nBody", "", "fix(api)!: 🚑 drop v1\n\nBody"},
+		{"suffix", "feat: add foo", "", "feat: add foo ✨"},
+		{"suffix", "docs: explain flags\n\nBody", "", "docs: explain flags 📝\n\nBody"},
+		{"prefix", "add foo", "feat", "✨ add foo"},
+		{"after-type", "add foo", "feat", "✨ add foo"},
+		{"suffix", "add foo", "feat", "add foo ✨"},
+		{"prefix", "perf: faster", "", "perf: faster"},
+		{"prefix", "", "feat", ""},
+	}
+	for _, tt := range tests {
+		if got := addGitmojiToCommitMessage(tt.msg, tt.commitType, tt.position, "unicode"); got != tt.want {
+			t.Errorf("addGitmojiToCommitMessage(%q, %q, %s) = %q, want %q", tt.msg, tt.commitType, tt.position, got, tt.want)
+		}
+	}
+}
+
+func TestDedupeMessages(t *testing.T) {
+	tests := []struct {
+		name string
+		msgs []string
+		want []string
+	}{
+		{"distinct", []string{"feat: add foo", "fix: bar"}, []string{"feat: add foo", "fix: bar"}},
+		{"case and punctuation", []string{"feat: add foo", "Feat: Add foo.", "feat - add foo!"}, []string{"feat: add foo"}},
+		{"emoji and spacing", []string{"✨ feat: add foo", "feat:  add   foo", "feat: add foo ✨"}, []string{"✨ feat: add foo"}},
+		{"empty options", []string{"", "  ", "…", "fix: bar"}, []string{"fix: bar"}},
+		{"keeps the first occurrence", []string{"fix: bar", "feat: add foo", "Fix: bar."}, []string{"fix: bar", "feat: add foo"}},
+		{"different words stay", []string{"feat: add foo", "feat: add foos"}, []string{"feat: add foo", "feat: add foos"}},
+	}
+	for _, tt := range tests {
+		if got := dedupeMessages(tt.msgs); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: dedupeMessages(%q) = %q, want %q", tt.name, tt.msgs, got, tt.want)
+		}
+	}
+}
+
+func TestBackfillOptions(t *testing.T) {
+	cfg, requests := ollamaStub(t, "Feat: add foo.", "docs: explain foo", "test: cover foo")
+	got := backfillOptions(context.Background(), cfg, sampleDiff, []string{"feat: add foo"}, 3)
+	if want := []string{"feat: add foo", "docs: explain foo", "test: cover foo"}; !slices.Equal(got, want) {
+		t.Errorf("backfillOptions() = %q, want %q", got, want)
+	}
+	if n := len(requests()); n != 3 {
+		t.Errorf("%d requests, want 3: the duplicate is replaced", n)
+	}
+}
+
+// TestListOptionsAreDistinct checks the menu the user is shown.
+func TestListOptionsAreDistinct(t *testing.T) {
+	dir := testRepo(t)
+	stageFile(t, dir, "foo.go", "package foo\n")
+
+	stdout, stderr, code := runCLI(t, dir, "1\n", []string{"LLAMAPUSHER_MOCK_RESPONSE=feat: add foo; Feat: add foo.;feat: add foo!; fix: handle bar"},
+		"--provider", "mock", "--no-emoji", "--list", "--dry-run", "--format", "json")
+	if code != 0 {
+		t.Fatalf("exit code %d, stderr:\n%s", code, stderr)
+	}
+	var menu struct{ Options []string }
+	if err := json.NewDecoder(strings.NewReader(stdout)).Decode(&menu); err != nil {
+		t.Fatalf("decoding %q: %v", stdout, err)
+	}
+	if want := []string{"feat: add foo", "fix: handle bar"}; !slices.Equal(menu.Options, want) {
+		t.Errorf("options = %q, want %q", menu.Options, want)
+	}
+}
+
+func TestCheckCommitType(t *testing.T) {
+	allowed := []string{"feat", "fix", "docs"}
+	tests := []struct {
+		msg     string
+		wantErr bool
+	}{
+		{"feat: add foo", false},
+		{"fix(api)!: drop v1", false},
+		{"Docs: explain flags", false},
+		{"chore: tidy", true},
+		{"style(ui): spacing\n\nfeat: not the header", true},
+		{"add foo without a type", false},
+	}
+	for _, tt := range tests {
+		if err := checkCommitType(tt.msg, allowed); (err != nil) != tt.wantErr {
+			t.Errorf("checkCommitType(%q) = %v, want error %v", tt.msg, err, tt.wantErr)
+		}
+	}
+}
+
+func TestAllowedTypes(t *testing.T) {
+	tests := []struct {
+		flag string
+		want []string
+	}{
+		{"", conventionalTypes},
+		{"feat,fix", []string{"feat", "fix"}},
+		{" feat , docs ,", []string{"feat", "docs"}},
+	}
+	for _, tt := range tests {
+		if got := (Config{AllowedTypes: tt.flag}).allowedTypes(); !slices.Equal(got, tt.want) {
+			t.Errorf("allowedTypes(%q) = %q, want %q", tt.flag, got, tt.want)
+		}
+	}
+}
+
+// TestAllowedTypesRetry checks that a message with a type outside
+// --allowed-types is regenerated, and that generation gives up with
+// ErrInvalidMessage if the model never complies.
+func TestAllowedTypesRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		responses    []string
+		want         string
+		wantRequests int
+	}{
+		{"allowed at once", []string{"fix: handle errors"}, "fix: handle errors", 1},
+		{"allowed on retry", []string{"style: reformat", "chore: tidy", "feat: add foo"}, "feat: add foo", 3},
+		{"never allowed", []string{"style: reformat"}, "", strictAttempts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, requests := ollamaStub(t, tt.responses...)
+			cfg.AllowedTypes = "feat,fix"
+			got, err := generateCommitMessage(context.Background(), cfg, sampleDiff)
+			if tt.want == "" {
+				if !errors.Is(err, ErrInvalidMessage) {
+					t.Errorf("generateCommitMessage() = %q, %v, want ErrInvalidMessage", got, err)
+				}
+			} else if err != nil || got != tt.want {
+				t.Errorf("generateCommitMessage() = %q, %v, want %q", got, err, tt.want)
+			}
+			if n := len(requests()); n != tt.wantRequests {
+				t.Errorf("%d requests, want %d", n, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestAllowedTypesPrompt(t *testing.T) {
+	dir := testRepo(t)
+	stageFile(t, dir, "main.go", "package main\n")
+
+	stdout, stderr, code := runCLI(t, dir, "", nil, "--provider", "mock", "--prompt-only", "--allowed-types", "feat,fix")
+	if code != 0 {
+		t.Fatalf("exit code %d, stderr:\n%s", code, stderr)
+	}
+	if want := "Only use one of these commit types: feat, fix."; !strings.Contains(stdout, want) {
+		t.Errorf("the prompt does not contain %q:\n%s", want, stdout)
+	}
+}
+
+// TestDetectCommitType stages files that an --auto-type rule does or does
+// not cover; the model is only asked when no rule applies.
+func TestDetectCommitType(t *testing.T) {
+	tests := []struct {
+		name         string
+		files        []string
+		allowed      string
+		want         string
+		wantRequests int
+	}{
+		{name: "dependencies", files: []string{"go.mod", "go.sum"}, want: "chore(deps)"},
+		{name: "docs", files: []string{"README.md", "docs/guide.txt"}, want: "docs"},
+		{name: "tests", files: []string{"main_test.go"}, want: "test"},
+		{name: "dependencies allowed", files: []string{"package.json"}, allowed: "chore,feat", want: "chore(deps)"},
+		{name: "dependencies not allowed", files: []string{"go.mod"}, allowed: "feat,fix", want: "feat", wantRequests: 1},
+		{name: "mixed files", files: []string{"go.mod", "main.go"}, want: "feat", wantRequests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := testRepo(t)
+			for _, name := range tt.files {
+				stageFile(t, dir, name, "content of "+name+"\n")
+			}
+			cfg, requests := ollamaStub(t, "feat")
+			cfg.AllowedTypes = tt.allowed
+			if got := detectCommitType(context.Background(), cfg, sampleDiff); got != tt.want {
+				t.Errorf("detectCommitType() = %q, want %q", got, tt.want)
+			}
+			if n := len(requests()); n != tt.wantRequests {
+				t.Errorf("%d requests, want %d", n, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestAutoTypeDependencies(t *testing.T) {
+	dir := testRepo(t)
+	stageFile(t, dir, "go.mod", "module example.com/foo\n")
+	stageFile(t, dir, "go.sum", "example.com/bar v1.0.0 h1:abc=\n")
+
+	stdout, stderr, code := runCLI(t, dir, "", nil, "--provider", "mock", "--message-only", "--no-emoji", "--auto-type")
+	if code != 0 {
+		t.Fatalf("exit code %d, stderr:\n%s", code, stderr)
+	}
+	if want := "chore(deps): update go.mod\n"; stdout != want {
+		t.Errorf("stdout %q, want %q", stdout, want)
+	}
+}
+
+func TestGitmojiStyle(t *testing.T) {
+	for commitType, emoji := range typeToGitmoji {
+		shortcode, ok := typeToShortcode[commitType]
+		if !ok {
+			t.Errorf("%s has a gitmoji but no shortcode", commitType)
+			continue
+		}
+		for style, want := range map[string]string{"unicode": emoji, "shortcode": shortcode} {
+			msg := commitType + ": change things"
+			if got := addGitmojiToCommitMessage(msg, "", "prefix", style); got != want+" "+msg {
+				t.Errorf("%s in %s style = %q, want %q", commitType, style, got, want+" "+msg)
+			}
+		}
+	}
+	if len(typeToShortcode) != len(typeToGitmoji) {
+		t.Errorf("%d shortcodes for %d gitmoji", len(typeToShortcode), len(typeToGitmoji))
+	}
+}
+
+func TestGitmojiShortcodePosition(t *testing.T) {
+	tests := []struct {
+		position string
+		want     string
+	}{
+		{"prefix", ":sparkles: feat: add foo"},
+		{"after-type", "feat: :sparkles: add foo"},
+		{"suffix", "feat: add foo :sparkles:"},
+	}
+	for _, tt := range tests {
+		if got := addGitmojiToCommitMessage("feat: add foo", "", tt.position, "shortcode"); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.position, got, tt.want)
+		}
+	}
+}
+
+func TestFirstLineOnly(t *testing.T) {
+	junk := "\n\n  feat: add login  \nThis commit adds login because users asked.\n\nExplanation:\n- it is good\n"
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   string
+	}{
+		{"off", func(*Config) {}, strings.TrimSpace(junk)},
+		{"first non-empty line", func(c *Config) { c.FirstLineOnly = true }, "feat: add login"},
+		{"before gitmoji", func(c *Config) { c.FirstLineOnly, c.Emoji = true, true }, "✨ feat: add login"},
+		{"before the template", func(c *Config) { c.FirstLineOnly, c.Template = true, "{COMMIT_MESSAGE}\n\nRefs: ABC-1" }, "feat: add login\n\nRefs: ABC-1"},
+		{"breaking gets the bang only", func(c *Config) { c.FirstLineOnly, c.Breaking = true, true }, "feat!: add login"},
+	}
+	for _, tt := range tests {
+		cfg := testConfig()
+		tt.modify(cfg)
+		if got := formatCommitMessage(cfg, junk); got != tt.want {
+			t.Errorf("%s: formatCommitMessage() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFirstLine(t *testing.T) {
+	for msg, want := range map[string]string{
+		"feat: a\nb":    "feat: a",
+		"\n \n feat: a": "feat: a",
+		"":              "",
+		" \n\t":         "",
+	} {
+		if got := firstLine(msg); got != want {
+			t.Errorf("firstLine(%q) = %q, want %q", msg, got, want)
+		}
+	}
+}
+
+func TestNormalizeCommitType(t *testing.T) {
+	for alias, want := range typeAliases {
+		if got := normalizeCommitType(alias); got != want {
+			t.Errorf("normalizeCommitType(%q) = %q, want %q", alias, got, want)
+		}
+		if !slices.Contains(conventionalTypes, want) {
+			t.Errorf("alias %q expands to %q, which is not a conventional type", alias, want)
+		}
+	}
+
+	tests := []struct {
+		commitType string
+		want       string
+	}{
+		{"", ""},
+		{"feat", "feat"},
+		{"Feature", "feat"},
+		{" BUGFIX ", "fix"},
+		{"feature(api)", "feat(api)"},
+		{"Documentation(README)", "docs(README)"},
+		{"banana", "banana"},
+	}
+	for _, tt := range tests {
+		if got := normalizeCommitType(tt.commitType); got != tt.want {
+			t.Errorf("normalizeCommitType(%q) = %q, want %q", tt.commitType, got, tt.want)
+		}
+	}
+}
+
+// TestCommitTypeAliasFlag checks an alias is expanded before the prompt and
+// the gitmoji, and that --strict and --allowed-types reject unknown types.
+func TestCommitTypeAliasFlag(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{name: "alias", args: []string{"--commit-type", "feature"}, wantStdout: "✨ feat: update main.go\n"},
+		{name: "alias with scope", args: []string{"--commit-type", "bugfix(api)"}, wantStdout: "🚑 fix(api): update main.go\n"},
+		{name: "unknown with --strict", args: []string{"--commit-type", "banana", "--strict"}, wantCode: exitError, wantStderr: `--commit-type "banana" is not a conventional commit type`},
+		{name: "alias outside --allowed-types", args: []string{"--commit-type", "documentation", "--allowed-types", "feat,fix"}, wantCode: exitError, wantStderr: `--commit-type "docs" is not in --allowed-types`},
+		{name: "alias inside --allowed-types", args: []string{"--commit-type", "feature", "--allowed-types", "feat,fix"}, wantStdout: "✨ feat: update main.go\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := testRepo(t)
+			stageFile(t, dir, "main.go", "package main\n")
+
+			stdout, stderr, code := runCLI(t, dir, "", nil, append([]string{"--provider", "mock", "--message-only"}, tt.args...)...)
+			if code != tt.wantCode {
+				t.Fatalf("exit code %d, want %d; stderr:\n%s", code, tt.wantCode, stderr)
+			}
+			if tt.wantStdout != "" && stdout != tt.wantStdout {
+				t.Errorf("stdout %q, want %q", stdout, tt.wantStdout)
+			}
+			if !strings.Contains(stderr, tt.wantStderr) {
+				t.Errorf("stderr does not mention %q:\n%s", tt.wantStderr, stderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// runEditPassthrough implements GIT_EDITOR="llamapusher --edit-passthrough":
+// git calls us with the commit message file, we prefill it with a generated
+// message and then hand over to the user's real editor. Generation problems
+// never block the commit; the editor opens either way.
+func runEditPassthrough(ctx context.Context, cfg *Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New("--edit-passthrough expects the commit message file as its only argument (set GIT_EDITOR=\"llamapusher --edit-passthrough\")")
+	}
+	if err := prefillMessageFile(ctx, cfg, args[0], false); err != nil {
+		return err
+	}
+	return runEditor(args[0])
+}
+
+// runPrepareCommitMsg implements the prepare-commit-msg hook, run either by
+// the pre-commit framework (see .pre-commit-hooks.yaml) or directly from
+// .git/hooks/prepare-commit-msg. args are the message file and, from git,
+// the message source. Commits whose message already comes from -m, a merge,
+// a squash or --amend are left alone, and a failed generation never stops
+// the commit.
+func runPrepareCommitMsg(ctx context.Context, cfg *Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("--prepare-commit-msg expects the commit message file as its first argument")
+	}
+
+	source := os.Getenv("PRE_COMMIT_COMMIT_MSG_SOURCE")
+	if len(args) > 1 {
+		source = args[1]
+	}
+	if source != "" && source != "template" {
+		debugf("message source is %q, leaving %s alone", source, args[0])
+		return nil
+	}
+
+	return prefillMessageFile(ctx, cfg, args[0], true)
+}
+
+// prefillMessageFile puts a generated message at the top of the commit
+// message file at path, unless it already holds a message. With seed, as
+// from the prepare-commit-msg hook, a message the user or a template has
+// started is passed to the model as a draft to build on instead; the
+// generated message replaces it and the draft is kept below as a comment.
+// Generation problems are only warnings, so the commit can go ahead without
+// one, and leave the file as it was.
+func prefillMessageFile(ctx context.Context, cfg *Config, path string, seed bool) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	rest := string(content)
+	if draft, comments := splitMessageDraft(rest); draft != "" {
+		if !seed {
+			debugf("%s already has a message, leaving it alone", path)
+			return nil
+		}
+		debugf("%s already has a message, using it as a draft", path)
+		seedCfg := *cfg
+		seedCfg.Instructions = strings.TrimSpace(cfg.Instructions + "\nThe author has already started the message as follows; keep its intent and any wording that fits, and complete it:\n" + draft)
+		cfg = &seedCfg
+		rest = "\n# Your draft, which the message above was generated from:\n" + commentOut(draft) + comments
+	}
+
+	if diff, err := getGitDiff(cfg); err != nil {
+		warnf("%v", err)
+	} else if diff == "" {
+		debugf("nothing staged, leaving %s without a generated message", path)
+	} else if err := checkDiffSize(ctx, cfg, diff); err != nil {
+		warnf("%v", err)
+	} else if msg, err := generateCommitMessage(ctx, cfg, diff); err != nil {
+		warnf("could not generate a commit message: %v", err)
+	} else {
+		if cfg.Signoff {
+			if signed, err := signOff(msg); err == nil {
+				msg = signed
+			} else {
+				warnf("%v", err)
+			}
+		}
+		content = []byte(msg + "\n" + rest)
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scissorsLine is the line git commit --verbose puts above the diff it
+// shows; git drops it and everything after it from the message.
+const scissorsLine = "# ------------------------ >8 ------------------------"
+
+// splitMessageDraft splits a commit message buffer into the message already
+// in it, without blank lines around it, and git's "#" comments, followed by
+// anything from the scissors line on.
+func splitMessageDraft(buffer string) (draft, comments string) {
+	var message []string
+	var b strings.Builder
+	for buffer != "" {
+		line, after, _ := strings.Cut(buffer, "\n")
+		if line == scissorsLine {
+			b.WriteString(buffer)
+			break
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			b.WriteString(line + "\n")
+		} else {
+			message = append(message, line)
+		}
+		buffer = after
+	}
+	return strings.TrimSpace(strings.Join(message, "\n")), b.String()
+}
+
+func commentOut(text string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		b.WriteString(strings.TrimRight("# "+line, " ") + "\n")
+	}
+	return b.String()
+}
+
+// realEditor finds the editor git would have used had GIT_EDITOR not pointed
+// at us. LLAMAPUSHER_EDITOR takes precedence for setups where core.editor is
+// also set to llamapusher.
+func realEditor() string {
+	if editor := os.Getenv("LLAMAPUSHER_EDITOR"); editor != "" {
+		return editor
+	}
+	if output, err := gitCommand("config", "--get", "core.editor").Output(); err == nil {
+		if editor := strings.TrimSpace(string(output)); editor != "" && !strings.Contains(editor, "--edit-passthrough") {
+			return editor
+		}
+	}
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if editor := os.Getenv(env); editor != "" {
+			return editor
+		}
+	}
+	return "vi"
+}
+
+func runEditor(path string) error {
+	editor := realEditor()
+	debugf("opening %s with %s", path, editor)
+
+	// Editors are configured as shell snippets ("code --wait"), as git does.
+	cmd := exec.Command("sh", "-c", editor+` "$@"`, editor, path)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
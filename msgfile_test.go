@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gitComments is what git puts in the message file for a plain git commit.
+const gitComments = "# Please enter the commit message for your changes. Lines starting\n# with '#' will be ignored.\n#\n# Changes to be committed:\n#\tmodified:   main.go\n"
+
+func TestSplitMessageDraft(t *testing.T) {
+	tests := []struct {
+		name         string
+		buffer       string
+		wantDraft    string
+		wantComments string
+	}{
+		{"empty", "", "", ""},
+		{"comments only", "\n" + gitComments, "", gitComments},
+		{"blank lines only", "\n\n  \n" + gitComments, "", gitComments},
+		{"seeded", "fix the login\n\n" + gitComments, "fix the login", gitComments},
+		{"seeded with a body", "\nfeat: add foo\n\nBecause bar.\n" + gitComments, "feat: add foo\n\nBecause bar.", gitComments},
+		{"indented comment", "  # not a message\n", "", "  # not a message\n"},
+		{
+			"scissors",
+			"wip\n" + scissorsLine + "\n# Do not modify or remove the line above.\ndiff --git a/main.go b/main.go\n+x\n",
+			"wip",
+			scissorsLine + "\n# Do not modify or remove the line above.\ndiff --git a/main.go b/main.go\n+x\n",
+		},
+	}
+	for _, tt := range tests {
+		draft, comments := splitMessageDraft(tt.buffer)
+		if draft != tt.wantDraft || comments != tt.wantComments {
+			t.Errorf("%s: splitMessageDraft() = %q, %q, want %q, %q", tt.name, draft, comments, tt.wantDraft, tt.wantComments)
+		}
+	}
+}
+
+// TestPrefillMessageFile runs the prepare-commit-msg hook on empty and
+// seeded buffers.
+func TestPrefillMessageFile(t *testing.T) {
+	tests := []struct {
+		name       string
+		buffer     string
+		seed       bool
+		wantFile   string
+		wantPrompt string
+		wantCalls  int
+	}{
+		{
+			name:      "empty buffer",
+			buffer:    "\n" + gitComments,
+			seed:      true,
+			wantFile:  "feat: add login\n\n" + gitComments,
+			wantCalls: 1,
+		},
+		{
+			name:       "seeded buffer",
+			buffer:     "login page\n\n" + gitComments,
+			seed:       true,
+			wantFile:   "feat: add login\n\n# Your draft, which the message above was generated from:\n# login page\n" + gitComments,
+			wantPrompt: "complete it:\nlogin page\n",
+			wantCalls:  1,
+		},
+		{
+			name:     "seeded buffer as the editor",
+			buffer:   "login page\n\n" + gitComments,
+			wantFile: "login page\n\n" + gitComments,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := testRepo(t)
+			stageFile(t, dir, "main.go", "package main\n")
+			path := filepath.Join(t.TempDir(), "COMMIT_EDITMSG")
+			writeTestFile(t, filepath.Dir(path), filepath.Base(path), tt.buffer)
+
+			cfg, requests := ollamaStub(t, "feat: add login")
+			if err := prefillMessageFile(context.Background(), cfg, path, tt.seed); err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.wantFile {
+				t.Errorf("message file:\n%q\nwant:\n%q", content, tt.wantFile)
+			}
+			calls := requests()
+			if len(calls) != tt.wantCalls {
+				t.Fatalf("%d requests, want %d", len(calls), tt.wantCalls)
+			}
+			if tt.wantPrompt != "" && !strings.Contains(calls[0].Prompt, tt.wantPrompt) {
+				t.Errorf("the prompt does not contain %q:\n%s", tt.wantPrompt, calls[0].Prompt)
+			}
+		})
+	}
+}
+
+// TestPrepareCommitMsgSource checks that messages git already has from -m,
+// a merge, a squash or --amend are left alone.
+func TestPrepareCommitMsgSource(t *testing.T) {
+	tests := []struct {
+		source      string
+		wantChanged bool
+	}{
+		{"", true},
+		{"template", true},
+		{"message", false},
+		{"merge", false},
+		{"squash", false},
+		{"commit", false},
+	}
+	for _, tt := range tests {
+		t.Run("source "+tt.source, func(t *testing.T) {
+			dir := testRepo(t)
+			stageFile(t, dir, "main.go", "package main\n")
+			path := filepath.Join(t.TempDir(), "COMMIT_EDITMSG")
+			writeTestFile(t, filepath.Dir(path), filepath.Base(path), "\n"+gitComments)
+
+			args := []string{path}
+			if tt.source != "" {
+				args = append(args, tt.source)
+			}
+			if err := runPrepareCommitMsg(context.Background(), testConfig(), args); err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed := string(content) != "\n"+gitComments; changed != tt.wantChanged {
+				t.Errorf("message file changed %v, want %v:\n%s", changed, tt.wantChanged, content)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// defaultProtectPatterns are files that usually hold credentials. They are
+// always protected unless --no-default-protect is given; --protect adds to
+// them.
+var defaultProtectPatterns = []string{
+	".env",
+	".env.local",
+	".env.*.local",
+	".env.production",
+	"*.pem",
+	"*.key",
+	"*.p12",
+	"*.pfx",
+	"*.jks",
+	"*.keystore",
+	"id_rsa",
+	"id_dsa",
+	"id_ecdsa",
+	"id_ed25519",
+	".netrc",
+	".htpasswd",
+	"*.kdbx",
+	"credentials.json",
+	"secrets/*",
+}
+
+func (c Config) protectPatterns() []string {
+	var patterns []string
+	if !c.NoDefaultProtect {
+		patterns = append(patterns, defaultProtectPatterns...)
+	}
+	return append(patterns, c.Protect...)
+}
+
+// matchGlob reports the first pattern file matches. Patterns are matched
+// against the whole path and against every trailing part of it, so ".env"
+// and "secrets/*" also catch "app/.env" and "app/secrets/token". It is
+// shared by --protect and --redact.
+func matchGlob(file string, patterns []string) (string, bool) {
+	parts := strings.Split(file, "/")
+	for _, pattern := range patterns {
+		for i := range parts {
+			if ok, _ := path.Match(pattern, strings.Join(parts[i:], "/")); ok {
+				return pattern, true
+			}
+		}
+	}
+	return "", false
+}
+
+// checkProtectedFiles refuses to go on when any staged file, whatever
+// --filter-files says, matches a protected pattern. Deleting such a file is
+// allowed.
+func checkProtectedFiles(cfg *Config) error {
+	patterns := cfg.protectPatterns()
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	output, err := gitCommand("diff", "--staged", "--name-only", "--diff-filter=d").Output()
+	if err != nil {
+		return err
+	}
+
+	var hits []string
+	for _, file := range splitLines(string(output)) {
+		if pattern, ok := matchGlob(file, patterns); ok {
+			hits = append(hits, fmt.Sprintf("%s (matches %q)", file, pattern))
+		}
+	}
+	if len(hits) == 0 {
+		return nil
+	}
+	return fmt.Errorf("🚨 refusing to commit protected file(s) that may contain secrets:\n  %s\nUnstage them with git restore --staged <file>, or see --protect and --no-default-protect", strings.Join(hits, "\n  "))
+}
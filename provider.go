@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Provider generates text for a prompt. cfg carries the model, sampling
+// options and endpoint; providers ignore what does not apply to them.
+type Provider interface {
+	Generate(ctx context.Context, cfg *Config, prompt string) (string, error)
+}
+
+// newProvider returns the --provider to use. With --offline it is also
+// where every endpoint is checked to be on this machine.
+func newProvider(cfg *Config) (Provider, error) {
+	switch cfg.Provider {
+	case "ollama":
+		if cfg.Offline {
+			for _, host := range cfg.ollamaURLs() {
+				if !isLocalURL(host) {
+					return nil, fmt.Errorf("--offline only allows a model on this machine, but --ollama-url includes %s", host)
+				}
+			}
+		}
+		return ollamaProvider{}, nil
+	case "mock":
+		return mockProvider{}, nil
+	}
+	return nil, fmt.Errorf("unknown --provider %q (expected ollama or mock)", cfg.Provider)
+}
+
+// isLocalURL reports whether raw points at localhost or a loopback
+// address. Names are not resolved, so only those spelled that way pass.
+func isLocalURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+type ollamaProvider struct{}
+
+func (ollamaProvider) Generate(ctx context.Context, cfg *Config, prompt string) (string, error) {
+	return generateWithModel(ctx, cfg, prompt)
+}
+
+// mockProvider answers without a model, so the whole flow can be run
+// offline and in CI. It returns $LLAMAPUSHER_MOCK_RESPONSE verbatim when that
+// is set (even to an empty string), and otherwise a message naming the
+// first file in the diff.
+type mockProvider struct{}
+
+var mockFileRe = regexp.MustCompile(`(?m)^\+\+\+ (?:b/)?(\S+)`)
+
+func (mockProvider) Generate(ctx context.Context, cfg *Config, prompt string) (string, error) {
+	if response, ok := os.LookupEnv("LLAMAPUSHER_MOCK_RESPONSE"); ok {
+		return response, nil
+	}
+
+	commitType := cfg.CommitType
+	if commitType == "" {
+		commitType = "chore"
+	}
+	if m := mockFileRe.FindStringSubmatch(prompt); m != nil && m[1] != "/dev/null" {
+		return commitType + ": update " + strings.TrimSpace(m[1]), nil
+	}
+	return commitType + ": update files", nil
+}
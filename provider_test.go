@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMockProvider(t *testing.T) {
+	tests := []struct {
+		name       string
+		commitType string
+		prompt     string
+		want       string
+	}{
+		{"names the first file", "", "FILES CHANGED:\n- modified: main.go\n--- main.go\n+++ main.go\n+x", "chore: update main.go"},
+		{"strips the b/ prefix", "", "+++ b/docs/README.md\n+x", "chore: update docs/README.md"},
+		{"uses --commit-type", "feat", "+++ main.go\n+x", "feat: update main.go"},
+		{"deleted file", "", "--- old.go\n+++ /dev/null\n-x", "chore: update files"},
+		{"no diff", "fix", "describe nothing", "fix: update files"},
+	}
+	t.Setenv("LLAMAPUSHER_MOCK_RESPONSE", "")
+	os.Unsetenv("LLAMAPUSHER_MOCK_RESPONSE")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mockProvider{}.Generate(context.Background(), &Config{CommitType: tt.commitType}, tt.prompt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Generate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMockProviderResponse(t *testing.T) {
+	for _, response := range []string{"feat: canned\n\nwith a body", ""} {
+		t.Setenv("LLAMAPUSHER_MOCK_RESPONSE", response)
+		got, err := mockProvider{}.Generate(context.Background(), &Config{}, "+++ main.go\n+x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != response {
+			t.Errorf("Generate() = %q, want $LLAMAPUSHER_MOCK_RESPONSE %q", got, response)
+		}
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	tests := []struct {
+		provider  string
+		offline   bool
+		ollamaURL string
+		wantErr   string
+	}{
+		{provider: "ollama", ollamaURL: defaultOllamaURL},
+		{provider: "mock"},
+		{provider: "openai", wantErr: `unknown --provider "openai"`},
+		{provider: "ollama", offline: true, ollamaURL: "http://127.0.0.1:11434/api/generate"},
+		{provider: "ollama", offline: true, ollamaURL: "http://localhost:11434/api/generate,http://gpu:11434/api/generate", wantErr: "http://gpu:11434"},
+	}
+
+	for _, tt := range tests {
+		_, err := newProvider(&Config{Provider: tt.provider, Offline: tt.offline, OllamaURL: tt.ollamaURL})
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("newProvider(%q) failed: %v", tt.provider, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("newProvider(%q, offline %v, %q) = %v, want an error mentioning %q", tt.provider, tt.offline, tt.ollamaURL, err, tt.wantErr)
+		}
+	}
+}
+
+// TestCommitWithMockProvider runs the whole commit flow without a model.
+func TestCommitWithMockProvider(t *testing.T) {
+	dir := testRepo(t)
+	stageFile(t, dir, "hello.txt", "hello\n")
+
+	_, stderr, code := runCLI(t, dir, "", nil, "--provider", "mock", "--force")
+	if code != 0 {
+		t.Fatalf("exit code %d, stderr:\n%s", code, stderr)
+	}
+	if got, want := git(t, "log", "-1", "--format=%s"), "🔧 chore: update hello.txt"; got != want {
+		t.Errorf("committed %q, want %q", got, want)
+	}
+}
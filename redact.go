@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// redactDiff replaces the hunks of files matching a --redact pattern with
+// a one-line note, so the model knows the file changed without seeing its
+// content. It works on raw git diff output, before the file and hunk
+// headers are stripped.
+func redactDiff(diff string, patterns []string) string {
+	var out []string
+	file, oldFile := "", ""
+	inHunks, redacting := false, false
+	changed := 0
+
+	flush := func() {
+		if redacting {
+			out = append(out, fmt.Sprintf("(redacted %d changed lines in %s)", changed, file))
+		}
+		inHunks, redacting, changed = false, false, 0
+	}
+
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			flush()
+		case !inHunks && strings.HasPrefix(line, "--- "):
+			oldFile = strings.TrimPrefix(line, "--- ")
+		case !inHunks && strings.HasPrefix(line, "+++ "):
+			file = strings.TrimPrefix(line, "+++ ")
+			if file == "/dev/null" {
+				file = oldFile
+			}
+			_, redacting = matchGlob(file, patterns)
+			inHunks = true
+		case redacting:
+			if strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-") {
+				changed++
+			}
+			continue
+		}
+		out = append(out, line)
+	}
+	flush()
+	return strings.Join(out, "\n")
+}
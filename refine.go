@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// defaultRefinePrompt is the --refine prompt; --refine-prompt replaces it
+// with a text/template file using the same fields.
+const defaultRefinePrompt = `Below is a draft git commit message and the git diff it describes. Rewrite the draft in {{.Language}} language so it is accurate, specific and concise: keep the conventional commits format (<type>: <subject>), use the imperative present tense, drop filler words and anything the diff does not show, and keep the subject under 72 characters. Reply with the improved commit message only.
+DRAFT:
+{{.Draft}}
+START OF GIT DIFF:
+{{.Diff}}
+END OF GIT DIFF`
+
+// refineData is what a --refine-prompt template can use.
+type refineData struct {
+	Draft    string
+	Diff     string
+	Language string
+}
+
+// refineMessage is the second pass of --refine: the draft and the diff go
+// back to the model to be tightened. A failed or empty refinement, or one
+// that no longer passes --strict or --allowed-types, keeps the draft.
+func refineMessage(ctx context.Context, cfg *Config, diff, draft string) (string, error) {
+	text := defaultRefinePrompt
+	if cfg.RefinePrompt != "" {
+		content, err := os.ReadFile(cfg.RefinePrompt)
+		if err != nil {
+			return "", fmt.Errorf("reading --refine-prompt: %w", err)
+		}
+		text = string(content)
+	}
+	tmpl, err := template.New("refine").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("--refine-prompt: %w", err)
+	}
+	var prompt strings.Builder
+	if err := tmpl.Execute(&prompt, refineData{Draft: strings.TrimSpace(draft), Diff: diff, Language: cfg.Language}); err != nil {
+		return "", fmt.Errorf("--refine-prompt: %w", err)
+	}
+
+	debugf("draft message:\n%s", strings.TrimSpace(draft))
+	refined, err := sendMessage(ctx, cfg, prompt.String())
+	if err != nil {
+		warnf("refining the message failed, keeping the draft: %v", err)
+		return draft, nil
+	}
+	if strings.TrimSpace(refined) == "" {
+		debugf("the refined message is empty, keeping the draft")
+		return draft, nil
+	}
+	if check := messageCheck(cfg); check != nil {
+		if err := check(refined); err != nil {
+			debugf("the refined message was rejected (%v), keeping the draft", err)
+			return draft, nil
+		}
+	}
+	debugf("refined message:\n%s", strings.TrimSpace(refined))
+	return refined, nil
+}
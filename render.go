@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const defaultSeparator = "------------------------------"
+
+// previewDiffLines is how much of the diff --preview-diff shows.
+const previewDiffLines = 200
+
+// renderProposal shows the proposed message in the --format chosen: human
+// is the decorated block, plain is the bare message and json is a single
+// {"message": ...} object.
+func renderProposal(cfg *Config, msg string) {
+	switch cfg.Format {
+	case "json":
+		writeJSON(map[string]any{"message": msg, "template": cfg.Template != ""})
+	case "plain":
+		fmt.Fprintln(resultOutput, msg)
+	default:
+		header := "Proposed Commit:"
+		if cfg.Template != "" {
+			header = "Proposed Commit With Template:"
+		}
+		fmt.Printf("%s\n%s\n%s\n%s\n", header, dim(cfg.Separator), highlightMessage(msg), dim(cfg.Separator))
+	}
+}
+
+// renderOptions shows the list-mode menu. The numbering is the same in every
+// format so the choice typed back means the same thing.
+func renderOptions(cfg *Config, msgs []string) {
+	switch cfg.Format {
+	case "json":
+		writeJSON(map[string]any{"options": msgs})
+		return
+	case "plain":
+		for i, msg := range msgs {
+			fmt.Printf("%d. %s\n", i+1, msg)
+		}
+	default:
+		fmt.Println("Select a commit message:")
+		for i, msg := range msgs {
+			fmt.Printf("%d. %s\n", i+1, highlightMessage(msg))
+		}
+	}
+	fmt.Printf("%d. %s\n", len(msgs)+1, regenerateMsg)
+}
+
+// previewDiff implements --preview-diff: it shows the diff exactly as it
+// will be sent, cut to previewDiffLines, and at a terminal asks whether to
+// go on before the model is called.
+func previewDiff(cfg *Config, diff string) error {
+	out := promptOutput(cfg)
+	lines := strings.Split(diff, "\n")
+	shown := lines[:min(len(lines), previewDiffLines)]
+
+	fmt.Fprintln(out, "Diff to be sent to the model:")
+	fmt.Fprintln(out, dim(cfg.Separator))
+	for _, line := range shown {
+		fmt.Fprintln(out, colorDiffLine(line))
+	}
+	if hidden := len(lines) - len(shown); hidden > 0 {
+		fmt.Fprintln(out, dim(fmt.Sprintf("... %d more lines not shown; the model still gets all of them", hidden)))
+	}
+	fmt.Fprintln(out, dim(cfg.Separator))
+
+	if cfg.Force || !isTerminal(os.Stdin) {
+		return nil
+	}
+	if !confirm(out, "Generate a message for these changes?", true) {
+		return userAborted(out, "Commit")
+	}
+	return nil
+}
+
+// promptOutput is where questions for the user go. With --format json or
+// --message-only they go to stderr so stdout stays parseable.
+func promptOutput(cfg *Config) io.Writer {
+	if cfg.Format == "json" || cfg.MessageOnly {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+func writeJSON(v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		warnf("could not encode the output: %v", err)
+		return
+	}
+	fmt.Fprintln(resultOutput, string(data))
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// emptyTree is git's well-known hash of the empty tree, the "parent" a root
+// commit is diffed against.
+const emptyTree = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+// runReword implements --mode reword: it regenerates the message of
+// --commit from that commit's own diff and rewrites it. HEAD is amended in
+// place, leaving whatever is staged alone; an older commit is reworded with
+// a non-interactive git rebase, which rewrites every commit after it. With
+// --range every commit in the range is reworded instead, by runRewordRange.
+func runReword(ctx context.Context, cfg *Config) error {
+	if cfg.Range != "" {
+		return runRewordRange(ctx, cfg)
+	}
+
+	sha, err := revParseCommit(cfg.Commit)
+	if err != nil {
+		return err
+	}
+	head, err := revParseCommit("HEAD")
+	if err != nil {
+		return err
+	}
+	if gitCommand("merge-base", "--is-ancestor", sha, head).Run() != nil {
+		return fmt.Errorf("%s is not an ancestor of HEAD, so it cannot be reworded from this branch", cfg.Commit)
+	}
+	if sha != head {
+		if output, _ := gitCommand("rev-list", "--merges", sha+"..HEAD").Output(); strings.TrimSpace(string(output)) != "" {
+			return errors.New("there are merge commits after " + cfg.Commit + "; rewording it would flatten them, reword it with git rebase -i --rebase-merges instead")
+		}
+	}
+
+	msg, root, err := rewordMessage(ctx, cfg, sha)
+	if err != nil {
+		return err
+	}
+	if current, err := gitCommand("log", "-1", "--format=%B", sha).Output(); err == nil {
+		infof("Current message:\n%s\n", strings.TrimSpace(string(current)))
+	}
+	renderProposal(cfg, msg)
+	copyMessage(cfg, msg)
+
+	if sha != head {
+		warnf("rewording %s rewrites it and every commit after it; they get new hashes", shortHash(sha))
+		if output, _ := gitCommand("branch", "-r", "--contains", sha).Output(); strings.TrimSpace(string(output)) != "" {
+			warnf("%s is already on a remote branch; pushing the result needs --force and affects anyone who has pulled it", shortHash(sha))
+		}
+	}
+	if cfg.DryRun {
+		infof("Dry run: nothing was reworded\n")
+		return nil
+	}
+	if !cfg.Force && !confirm(promptOutput(cfg), "Reword the commit?", cfg.Yes) {
+		return userAborted(promptOutput(cfg), "Reword")
+	}
+
+	if cfg.Signoff {
+		if msg, err = signOff(msg); err != nil {
+			return err
+		}
+	}
+	if sha == head {
+		return amendMessage(msg)
+	}
+	return rebaseReword(sha, root, msg)
+}
+
+// rewordMessage generates a new message for sha from its own diff; root is
+// true when sha has no parent.
+func rewordMessage(ctx context.Context, cfg *Config, sha string) (msg string, root bool, err error) {
+	parent, root := emptyTree, true
+	if p, err := revParseCommit(sha + "^"); err == nil {
+		parent, root = p, false
+	}
+
+	rewordCfg := *cfg
+	rewordCfg.Range = parent + ".." + sha
+	diff, err := getGitDiff(&rewordCfg)
+	if err != nil {
+		return "", root, err
+	}
+	if diff == "" {
+		return "", root, fmt.Errorf("%s has no changes to describe", shortHash(sha))
+	}
+	if err := checkDiffSize(ctx, &rewordCfg, diff); err != nil {
+		return "", root, err
+	}
+	if rewordCfg.AutoType && rewordCfg.CommitType == "" {
+		rewordCfg.CommitType = detectCommitType(ctx, &rewordCfg, diff)
+	}
+	if err := applyTypeSampling(&rewordCfg); err != nil {
+		return "", root, err
+	}
+
+	msg, err = generateCommitMessage(ctx, &rewordCfg, diff)
+	return msg, root, err
+}
+
+// runRewordRange rewords every commit in --range, which has to end at an
+// ancestor of HEAD and hold no merges. All the new messages are generated
+// and shown as a plan first; only --force goes on to rewrite them, in one
+// rebase that amends each commit as it is replayed. The working tree has to
+// be clean so nothing is stashed or lost along the way.
+func runRewordRange(ctx context.Context, cfg *Config) error {
+	output, err := gitCommand("rev-list", "--reverse", cfg.Range).Output()
+	if err != nil {
+		return fmt.Errorf("listing the commits in %s: %w", cfg.Range, err)
+	}
+	commits := splitLines(string(output))
+	if len(commits) == 0 {
+		return fmt.Errorf("%w: %s has no commits 🙅", ErrNoChanges, cfg.Range)
+	}
+	if gitCommand("merge-base", "--is-ancestor", commits[len(commits)-1], "HEAD").Run() != nil {
+		return fmt.Errorf("%s does not end at an ancestor of HEAD, so it cannot be reworded from this branch", cfg.Range)
+	}
+
+	base, root := emptyTree, true
+	if p, err := revParseCommit(commits[0] + "^"); err == nil {
+		base, root = p, false
+	}
+	replay := "HEAD"
+	if !root {
+		replay = base + "..HEAD"
+	}
+	if output, _ := gitCommand("rev-list", "--merges", replay).Output(); strings.TrimSpace(string(output)) != "" {
+		return errors.New("there are merge commits in or after " + cfg.Range + "; rewording would flatten them, use git rebase -i --rebase-merges instead")
+	}
+
+	if cfg.Force && !cfg.DryRun {
+		if output, _ := gitCommand("status", "--porcelain", "--untracked-files=no").Output(); strings.TrimSpace(string(output)) != "" {
+			return errors.New("the working tree has uncommitted changes; commit or stash them before rewording a range")
+		}
+	}
+
+	messages := map[string]string{}
+	infof("Rewording %d commit(s):\n", len(commits))
+	for i, sha := range commits {
+		msg, _, err := rewordMessage(ctx, cfg, sha)
+		if err != nil {
+			return err
+		}
+		if cfg.Signoff {
+			if msg, err = signOff(msg); err != nil {
+				return err
+			}
+		}
+		messages[sha] = msg
+		subject, _ := gitCommand("log", "-1", "--format=%s", sha).Output()
+		infof("%d. %s %s\n   -> %s\n", i+1, shortHash(sha), strings.TrimSpace(string(subject)), firstLine(msg))
+	}
+
+	warnf("this rewrites %d commit(s) and every commit after them; they get new hashes", len(commits))
+	if output, _ := gitCommand("branch", "-r", "--contains", commits[0]).Output(); strings.TrimSpace(string(output)) != "" {
+		warnf("%s is already on a remote branch; pushing the result needs --force and affects anyone who has pulled it", shortHash(commits[0]))
+	}
+	if cfg.DryRun {
+		infof("Dry run: nothing was reworded\n")
+		return nil
+	}
+	if !cfg.Force {
+		return fmt.Errorf("%w: rewording a range rewrites history, so it needs --force; rerun with it once the plan above looks right", ErrAborted)
+	}
+	return rebaseRewordRange(replay, root, messages)
+}
+
+// rebaseRewordRange replays replay with a todo list written up front:
+// every commit is picked, and each one in messages is followed by an exec
+// that amends it with its new message.
+func rebaseRewordRange(replay string, root bool, messages map[string]string) error {
+	dir, err := os.MkdirTemp("", "llamapusher-reword-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	output, err := gitCommand("rev-list", "--reverse", replay).Output()
+	if err != nil {
+		return err
+	}
+	var todo strings.Builder
+	for i, sha := range splitLines(string(output)) {
+		fmt.Fprintf(&todo, "pick %s\n", sha)
+		msg, ok := messages[sha]
+		if !ok {
+			continue
+		}
+		file := filepath.Join(dir, fmt.Sprintf("msg-%d.txt", i))
+		if err := os.WriteFile(file, []byte(msg+"\n"), 0o600); err != nil {
+			return err
+		}
+		fmt.Fprintf(&todo, "exec %s commit --amend --only --no-verify --allow-empty -F %s\n", shellQuote(gitPath), shellQuote(file))
+	}
+	todoFile := filepath.Join(dir, "todo")
+	if err := os.WriteFile(todoFile, []byte(todo.String()), 0o600); err != nil {
+		return err
+	}
+
+	args := []string{"rebase", "-i"}
+	if root {
+		args = append(args, "--root")
+	} else {
+		args = append(args, strings.TrimSuffix(replay, "..HEAD"))
+	}
+	cmd := gitCommand(args...)
+	cmd.Env = append(os.Environ(), "GIT_SEQUENCE_EDITOR=cp "+shellQuote(todoFile))
+
+	infof("Rewording %d commit(s)... 🚀\n", len(messages))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git rebase failed (%v); if it stopped part way, git rebase --abort restores the branch:\n%s", err, strings.TrimSpace(string(output)))
+	}
+	infof("Commits reworded! 🎉\n")
+	return nil
+}
+
+func revParseCommit(rev string) (string, error) {
+	output, err := gitCommand("rev-parse", "--verify", "--quiet", rev+"^{commit}").Output()
+	if err != nil {
+		return "", fmt.Errorf("%q is not a known commit", rev)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func shortHash(sha string) string {
+	if output, err := gitCommand("rev-parse", "--short", sha).Output(); err == nil {
+		return strings.TrimSpace(string(output))
+	}
+	return sha
+}
+
+// amendMessage rewords HEAD. --only keeps staged changes out of the commit.
+func amendMessage(msg string) error {
+	infof("Rewording HEAD... 🚀\n")
+	output, err := gitCommand("commit", "--amend", "--only", "--no-verify", "-m", msg).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git commit --amend failed (%v):\n%s", err, strings.TrimSpace(string(output)))
+	}
+	infof("Commit reworded! 🎉\n")
+	return nil
+}
+
+// rebaseReword runs git rebase -i with the todo list rewritten so the first
+// entry, always the commit being reworded since the rebase starts at its
+// parent, is "reword". The editor git opens for it is replaced by a copy of
+// the new message. Uncommitted changes are stashed around the rebase.
+func rebaseReword(sha string, root bool, msg string) error {
+	file, err := os.CreateTemp("", "llamapusher-reword-*.txt")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(msg + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	args := []string{"rebase", "-i", "--autostash"}
+	if root {
+		args = append(args, "--root")
+	} else {
+		args = append(args, sha+"^")
+	}
+	cmd := gitCommand(args...)
+	cmd.Env = append(os.Environ(),
+		"GIT_SEQUENCE_EDITOR=sed -i.bak -e '1s/^pick /reword /'",
+		"GIT_EDITOR=cp "+shellQuote(file.Name()),
+	)
+
+	infof("Rewording %s... 🚀\n", shortHash(sha))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git rebase failed (%v); if it stopped part way, git rebase --abort restores the branch:\n%s", err, strings.TrimSpace(string(output)))
+	}
+	infof("Commit reworded! 🎉\n")
+	return nil
+}
+
+// shellQuote quotes s for sh, which git runs the editor commands with.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// rewordRepo makes a repository with three commits, newest first in
+// subjects, and a staged change that rewording must leave alone.
+func rewordRepo(t *testing.T) string {
+	t.Helper()
+	dir := testRepo(t)
+	for _, c := range [][2]string{{"a.go", "init"}, {"b.go", "wip"}, {"pkg/c.go", "stuff"}} {
+		stageFile(t, dir, c[0], "package main\n")
+		git(t, "commit", "-q", "-m", c[1])
+	}
+	stageFile(t, dir, "d.go", "package main\n")
+	return dir
+}
+
+func subjects(t *testing.T) []string {
+	t.Helper()
+	return strings.Split(git(t, "log", "--format=%s"), "\n")
+}
+
+func TestReword(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		answers      string
+		wantCode     int
+		wantSubjects []string
+	}{
+		{name: "HEAD accepted", answers: "y\n", wantSubjects: []string{"chore: update pkg/c.go", "wip", "init"}},
+		{name: "HEAD declined", answers: "n\n", wantCode: exitAborted, wantSubjects: []string{"stuff", "wip", "init"}},
+		{name: "HEAD forced", args: []string{"--force"}, wantSubjects: []string{"chore: update pkg/c.go", "wip", "init"}},
+		{name: "older commit", args: []string{"--commit", "HEAD~1"}, answers: "y\n", wantSubjects: []string{"stuff", "chore: update b.go", "init"}},
+		{name: "root commit", args: []string{"--commit", "HEAD~2"}, answers: "y\n", wantSubjects: []string{"stuff", "wip", "chore: update a.go"}},
+		{name: "dry run", args: []string{"--dry-run"}, wantSubjects: []string{"stuff", "wip", "init"}},
+		{name: "unknown commit", args: []string{"--commit", "nope"}, wantCode: exitError, wantSubjects: []string{"stuff", "wip", "init"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := rewordRepo(t)
+			tree := git(t, "rev-parse", "HEAD^{tree}")
+
+			args := append([]string{"--provider", "mock", "--no-emoji", "--mode", "reword"}, tt.args...)
+			stdout, stderr, code := runCLI(t, dir, tt.answers, nil, args...)
+			if code != tt.wantCode {
+				t.Fatalf("exit code %d, want %d; stdout:\n%s\nstderr:\n%s", code, tt.wantCode, stdout, stderr)
+			}
+			if got := subjects(t); !slices.Equal(got, tt.wantSubjects) {
+				t.Errorf("commits %q, want %q", got, tt.wantSubjects)
+			}
+			if got := git(t, "rev-parse", "HEAD^{tree}"); got != tree {
+				t.Error("rewording changed the content of HEAD")
+			}
+			if got := git(t, "diff", "--staged", "--name-only"); got != "d.go" {
+				t.Errorf("staged %q, want d.go left staged", got)
+			}
+		})
+	}
+}
+
+func TestRewordRange(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		dirty        bool
+		wantCode     int
+		wantSubjects []string
+		wantStderr   string
+	}{
+		{name: "forced", args: []string{"--range", "HEAD~2..HEAD", "--force"}, wantSubjects: []string{"chore: update pkg/c.go", "chore: update b.go", "init"}},
+		{name: "ending before HEAD", args: []string{"--range", "HEAD~2..HEAD~1", "--force"}, wantSubjects: []string{"stuff", "chore: update b.go", "init"}},
+		{name: "without --force", args: []string{"--range", "HEAD~2..HEAD"}, wantCode: exitAborted, wantSubjects: []string{"stuff", "wip", "init"}, wantStderr: "needs --force"},
+		{name: "dry run", args: []string{"--range", "HEAD~2..HEAD", "--dry-run"}, wantSubjects: []string{"stuff", "wip", "init"}},
+		{name: "uncommitted changes", args: []string{"--range", "HEAD~2..HEAD", "--force"}, dirty: true, wantCode: exitError, wantSubjects: []string{"stuff", "wip", "init"}, wantStderr: "uncommitted changes"},
+		{name: "empty range", args: []string{"--range", "HEAD..HEAD", "--force"}, wantCode: exitNoChanges, wantSubjects: []string{"stuff", "wip", "init"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := rewordRepo(t)
+			if !tt.dirty {
+				git(t, "rm", "-q", "--cached", "d.go")
+			}
+			tree := git(t, "rev-parse", "HEAD^{tree}")
+
+			args := append([]string{"--provider", "mock", "--no-emoji", "--mode", "reword"}, tt.args...)
+			stdout, stderr, code := runCLI(t, dir, "", nil, args...)
+			if code != tt.wantCode {
+				t.Fatalf("exit code %d, want %d; stdout:\n%s\nstderr:\n%s", code, tt.wantCode, stdout, stderr)
+			}
+			if got := subjects(t); !slices.Equal(got, tt.wantSubjects) {
+				t.Errorf("commits %q, want %q", got, tt.wantSubjects)
+			}
+			if got := git(t, "rev-parse", "HEAD^{tree}"); got != tree {
+				t.Error("rewording changed the content of HEAD")
+			}
+			if !strings.Contains(stderr, tt.wantStderr) {
+				t.Errorf("stderr does not mention %q:\n%s", tt.wantStderr, stderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// secretAllowMarker on an added line stops --scan-secrets reporting it, for
+// test fixtures and other known false positives.
+const secretAllowMarker = "llamapusher:allow-secret"
+
+var secretPatterns = []struct {
+	name string
+	re   *regexp.Regexp
+}{
+	{"private key", regexp.MustCompile(`-----BEGIN (?:[A-Z]+ )?PRIVATE KEY( BLOCK)?-----`)},
+	{"AWS access key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"GitHub token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{40,})\b`)},
+	{"GitLab token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}\b`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`)},
+	{"Stripe key", regexp.MustCompile(`\b[sr]k_live_[A-Za-z0-9]{20,}\b`)},
+	{"Google API key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{"JSON web token", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+	{"hard-coded credential", regexp.MustCompile(`(?i)(?:password|passwd|secret|api[_-]?key|access[_-]?token|auth[_-]?token)["']?\s*[:=]\s*["'][^"'\s]{8,}["']`)},
+}
+
+// highEntropyRe finds the long token-like strings checked for entropy.
+var highEntropyRe = regexp.MustCompile(`[A-Za-z0-9+/=_-]{32,}`)
+
+// secretFinding is one suspected secret on an added line of the diff.
+type secretFinding struct {
+	file  string
+	kind  string
+	match string
+}
+
+func (f secretFinding) String() string {
+	return fmt.Sprintf("%s: %s %s", f.file, f.kind, maskSecret(f.match))
+}
+
+// scanForSecrets looks at the lines the diff adds, ignoring those marked
+// with secretAllowMarker.
+func scanForSecrets(diff string) []secretFinding {
+	var findings []secretFinding
+	file := ""
+	for _, line := range strings.Split(diff, "\n") {
+		if name, ok := strings.CutPrefix(line, "+++ "); ok {
+			file = name
+			continue
+		}
+		if !strings.HasPrefix(line, "+") || strings.Contains(line, secretAllowMarker) {
+			continue
+		}
+
+		found := false
+		for _, p := range secretPatterns {
+			if m := p.re.FindString(line); m != "" {
+				findings = append(findings, secretFinding{file, p.name, m})
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		for _, token := range highEntropyRe.FindAllString(line, -1) {
+			if shannonEntropy(token) >= 4.5 {
+				findings = append(findings, secretFinding{file, "high-entropy string", token})
+				break
+			}
+		}
+	}
+	return findings
+}
+
+// shannonEntropy is the entropy of s in bits per character. Random base64
+// is close to 6, English text and identifiers are well under 4.5.
+func shannonEntropy(s string) float64 {
+	counts := map[rune]int{}
+	for _, r := range s {
+		counts[r]++
+	}
+	var entropy float64
+	n := float64(len(s))
+	for _, c := range counts {
+		p := float64(c) / n
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// maskSecret keeps just enough of a suspected secret to recognise it.
+func maskSecret(s string) string {
+	if len(s) <= 8 {
+		return strings.Repeat("*", len(s))
+	}
+	return s[:4] + strings.Repeat("*", min(len(s)-8, 12)) + s[len(s)-4:]
+}
+
+// checkSecrets runs --scan-secrets on the diff before it is sent to the
+// model. With --redact the scan reads the diff again without redaction:
+// redacted files are the ones most likely to hold secrets, and keeping them
+// from the model does not keep them out of the commit. Anything found has
+// to be confirmed at the terminal; without one, or with --force, the commit
+// is refused.
+func checkSecrets(cfg *Config, diff string) error {
+	if len(cfg.Redact) > 0 {
+		rawCfg := *cfg
+		rawCfg.Redact = nil
+		raw, err := getGitDiff(&rawCfg)
+		if err != nil {
+			return err
+		}
+		diff = raw
+	}
+
+	findings := scanForSecrets(diff)
+	if len(findings) == 0 {
+		debugf("secret scan found nothing")
+		return nil
+	}
+
+	lines := make([]string, len(findings))
+	for i, f := range findings {
+		lines[i] = f.String()
+	}
+	fmt.Fprintf(os.Stderr, "🚨 The staged changes look like they contain %d secret(s):\n  %s\n", len(findings), strings.Join(lines, "\n  "))
+
+	const hint = "mark false positives with a '" + secretAllowMarker + "' comment on the line"
+	if cfg.Force || !isTerminal(os.Stdin) {
+		return fmt.Errorf("refusing to continue with possible secrets in the diff; unstage them or %s", hint)
+	}
+	if !confirm(promptOutput(cfg), "Continue anyway?", false) {
+		return userAborted(promptOutput(cfg), "Commit")
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// splitGroup is a set of staged files --split commits together.
+type splitGroup struct {
+	label      string
+	commitType string // from autoTypeRules, "" for directory groups
+	paths      []string
+}
+
+// planSplit groups the staged files: everything a --auto-type rule matches
+// (docs, tests, dependencies) goes in that rule's group, the rest by
+// top-level directory. Both sides of a rename stay in one group.
+func planSplit() ([]splitGroup, error) {
+	output, err := gitCommand("diff", "--staged", "--name-status", "-M").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []splitGroup
+	index := map[string]int{}
+	for _, line := range splitLines(string(output)) {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 {
+			continue
+		}
+		paths := fields[1:]
+		file := paths[len(paths)-1]
+
+		label, commitType := "", ""
+		for _, rule := range autoTypeRules {
+			if rule.matches(file) {
+				label, commitType = rule.Type, rule.Type
+				break
+			}
+		}
+		if label == "" {
+			label = "(top level)"
+			if dir, _, ok := strings.Cut(file, "/"); ok {
+				label = dir + "/"
+			}
+		}
+
+		i, ok := index[label]
+		if !ok {
+			i = len(groups)
+			index[label] = i
+			groups = append(groups, splitGroup{label: label, commitType: commitType})
+		}
+		groups[i].paths = append(groups[i].paths, paths...)
+	}
+	return groups, nil
+}
+
+func printSplitPlan(groups []splitGroup) {
+	infof("Planned split into %d commit(s):\n", len(groups))
+	for i, g := range groups {
+		infof("%d. %s\n", i+1, g.label)
+		for _, p := range g.paths {
+			infof("     %s\n", p)
+		}
+	}
+}
+
+// runSplit is the experimental --split mode. The staged tree is saved with
+// git write-tree, then for each group the index is reset to HEAD and only
+// that group's paths are restored from the saved tree, so partially staged
+// files keep exactly their staged content. Each commit is confirmed. At the
+// end, or if a group fails, the index is set back to the saved tree, which
+// leaves whatever was not committed staged as before.
+func runSplit(ctx context.Context, cfg *Config) error {
+	groups, err := planSplit()
+	if err != nil {
+		return err
+	}
+	printSplitPlan(groups)
+	if cfg.DryRun {
+		infof("Dry run: nothing was committed\n")
+		return nil
+	}
+	if len(groups) < 2 {
+		infof("Nothing to split, committing as usual\n")
+		diff, err := getGitDiff(cfg)
+		if err != nil {
+			return err
+		}
+		return generateSingleCommit(ctx, cfg, diff)
+	}
+
+	output, err := gitCommand("write-tree").Output()
+	if err != nil {
+		return fmt.Errorf("git write-tree failed: %w", err)
+	}
+	staged := strings.TrimSpace(string(output))
+	warnf("--split rewrites the staging area; if it is interrupted, git read-tree %s puts it back", staged)
+	defer restoreIndex(staged)
+
+	committed := 0
+	for i, g := range groups {
+		if isInterrupted() {
+			return errInterrupted
+		}
+		infof("\nCommit %d of %d: %s\n", i+1, len(groups), g.label)
+		if err := stageOnly(staged, g.paths); err != nil {
+			return err
+		}
+
+		groupCfg := *cfg
+		if groupCfg.CommitType == "" && g.commitType != "" && slices.Contains(cfg.allowedTypes(), strings.SplitN(g.commitType, "(", 2)[0]) {
+			groupCfg.CommitType = g.commitType
+		}
+		diff, err := getGitDiff(&groupCfg)
+		if err != nil {
+			return err
+		}
+		if groupCfg.AutoType && groupCfg.CommitType == "" {
+			groupCfg.CommitType = detectCommitType(ctx, &groupCfg, diff)
+		}
+		if err := applyTypeSampling(&groupCfg); err != nil {
+			return err
+		}
+
+		msg, err := generateCommitMessage(ctx, &groupCfg, diff)
+		if err != nil {
+			return err
+		}
+		renderProposal(&groupCfg, msg)
+		writeOutputFD(cfg, msg)
+		if !confirm(promptOutput(cfg), "Commit this group?", cfg.Yes) {
+			infof("Skipped, its changes stay staged\n")
+			continue
+		}
+		if err := runCommit(&groupCfg, msg); err != nil {
+			return err
+		}
+		committed++
+	}
+	infof("\nMade %d of %d commit(s)\n", committed, len(groups))
+	return nil
+}
+
+// stageOnly resets the index to HEAD, or empties it before the first
+// commit, and restores paths from tree.
+func stageOnly(tree string, paths []string) error {
+	reset := []string{"read-tree", "--empty"}
+	if gitCommand("rev-parse", "--verify", "--quiet", "HEAD").Run() == nil {
+		reset = []string{"read-tree", "HEAD"}
+	}
+	if output, err := gitCommand(reset...).CombinedOutput(); err != nil {
+		return fmt.Errorf("git %s failed (%v):\n%s", strings.Join(reset, " "), err, strings.TrimSpace(string(output)))
+	}
+	args := append([]string{"restore", "--staged", "--source=" + tree, "--"}, paths...)
+	if output, err := gitCommand(args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("git restore failed (%v):\n%s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func restoreIndex(tree string) {
+	if output, err := gitCommand("read-tree", tree).CombinedOutput(); err != nil {
+		errorf("could not restore the staging area (%v), run git read-tree %s:\n%s", err, tree, strings.TrimSpace(string(output)))
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPlanSplit(t *testing.T) {
+	dir := testRepo(t)
+	stageFile(t, dir, "old.go", "package main\n\nfunc old() {}\n")
+	git(t, "commit", "-q", "-m", "init")
+	for _, name := range []string{"README.md", "main.go", "main_test.go", "go.mod", "cmd/app/main.go", "docs/guide.txt"} {
+		stageFile(t, dir, name, "content of "+name+"\n")
+	}
+	git(t, "mv", "old.go", "cmd/old.go")
+
+	groups, err := planSplit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []splitGroup{
+		{label: "docs", commitType: "docs", paths: []string{"README.md", "docs/guide.txt"}},
+		{label: "cmd/", paths: []string{"cmd/app/main.go", "old.go", "cmd/old.go"}},
+		{label: "chore(deps)", commitType: "chore(deps)", paths: []string{"go.mod"}},
+		{label: "(top level)", paths: []string{"main.go"}},
+		{label: "test", commitType: "test", paths: []string{"main_test.go"}},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("planSplit() =\n%+v\nwant\n%+v", groups, want)
+	}
+}
+
+// TestSplitCommits answers each --split confirmation in turn and checks what
+// was committed and what is left staged. main.go is only partly staged.
+func TestSplitCommits(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		answers      string
+		wantSubjects []string
+		wantStaged   []string
+	}{
+		{
+			name:         "all accepted",
+			answers:      "y\ny\ny\n",
+			wantSubjects: []string{"chore: update pkg/a.go", "chore: update main.go", "docs: update README.md"},
+		},
+		{
+			name:         "one skipped",
+			answers:      "y\nn\ny\n",
+			wantSubjects: []string{"chore: update pkg/a.go", "docs: update README.md"},
+			wantStaged:   []string{"main.go"},
+		},
+		{
+			name:       "all skipped",
+			answers:    "n\nn\nn\n",
+			wantStaged: []string{"README.md", "main.go", "pkg/a.go"},
+		},
+		{
+			name:       "dry run",
+			args:       []string{"--dry-run"},
+			wantStaged: []string{"README.md", "main.go", "pkg/a.go"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := testRepo(t)
+			stageFile(t, dir, "main.go", "package main\n")
+			git(t, "commit", "-q", "-m", "init")
+			stageFile(t, dir, "README.md", "# readme\n")
+			stageFile(t, dir, "main.go", "package main\n\nfunc main() {}\n")
+			stageFile(t, dir, "pkg/a.go", "package pkg\n")
+			writeTestFile(t, dir, "main.go", "package main\n\nfunc main() { println() }\n")
+			stagedTree := git(t, "write-tree")
+
+			args := append([]string{"--provider", "mock", "--no-emoji", "--split"}, tt.args...)
+			stdout, stderr, code := runCLI(t, dir, tt.answers, nil, args...)
+			if code != 0 {
+				t.Fatalf("exit code %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+			}
+
+			subjects := strings.Split(git(t, "log", "--format=%s"), "\n")
+			if got := subjects[:len(subjects)-1]; !slices.Equal(got, tt.wantSubjects) {
+				t.Errorf("commits %q, want %q", got, tt.wantSubjects)
+			}
+			if got := git(t, "diff", "--staged", "--name-only"); got != strings.Join(tt.wantStaged, "\n") {
+				t.Errorf("staged %q, want %q", got, tt.wantStaged)
+			}
+			if len(tt.wantSubjects) == 0 && git(t, "write-tree") != stagedTree {
+				t.Error("the staging area was not restored")
+			}
+			if got := git(t, "diff", "--name-only"); got != "main.go" {
+				t.Errorf("unstaged changes %q, want the rest of main.go", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// runTUI is the --tui cockpit: one screen showing the staged files and the
+// proposed message, with single-key commands (each followed by Enter) to
+// regenerate, edit, switch model or temperature, toggle emoji, view the
+// diff and commit. It is line based rather than a full-screen split view:
+// the screen is redrawn after each command, the diff is shown on its own
+// page and the message is edited in $EDITOR. It needs a terminal; main
+// falls back to the normal flow without one.
+func runTUI(ctx context.Context, cfg *Config, diff string) error {
+	tuiCfg := *cfg
+	cfg = &tuiCfg
+	files, err := getFileList(cfg)
+	if err != nil {
+		return err
+	}
+
+	msg, err := generateCommitMessage(ctx, cfg, diff)
+	if err != nil {
+		return err
+	}
+	status, edited := "", false
+
+	for {
+		clearScreen()
+		fmt.Printf("%s\n%s\n\n", cyan("Staged changes"), files)
+		fmt.Printf("%s  %s\n", cyan("Commit message"), dim(fmt.Sprintf("(model %s, temperature %.2f, emoji %s)", cfg.Model, cfg.Temperature, onOff(cfg.Emoji))))
+		fmt.Printf("%s\n%s\n%s\n\n", dim(cfg.Separator), highlightMessage(msg), dim(cfg.Separator))
+		if status != "" {
+			fmt.Println(status)
+			status = ""
+		}
+		fmt.Println("[c]ommit  [r]egenerate  [e]dit  [m]odel  [t]emperature  emo[j]i  [d]iff  [q]uit")
+		fmt.Print("> ")
+
+		input, err := readLine()
+		input = strings.ToLower(strings.TrimSpace(input))
+		if err != nil && input == "" {
+			if errors.Is(err, errInterrupted) {
+				return err
+			}
+			fmt.Println()
+			return fmt.Errorf("%w: no command was entered", ErrAborted)
+		}
+		switch input {
+		case "c":
+			writeOutputFD(cfg, msg)
+			return runCommit(cfg, msg)
+		case "q":
+			return userAborted(os.Stdout, "Commit")
+		case "r":
+			regenCfg := *cfg
+			regenCfg.NoCache = true
+			if regenCfg.Seed >= 0 {
+				cfg.Seed++
+				regenCfg.Seed = cfg.Seed
+			}
+			msg, edited, status = tuiGenerate(ctx, &regenCfg, diff, msg, edited)
+		case "e":
+			if updated, err := editMessage(msg); err != nil {
+				status = "Edit failed: " + err.Error()
+			} else if updated != "" {
+				msg, edited = updated, true
+			}
+		case "m":
+			if model := tuiAsk("Model: ", cfg.Model); model != cfg.Model {
+				cfg.Model = model
+				msg, edited, status = tuiGenerate(ctx, cfg, diff, msg, edited)
+			}
+		case "t":
+			value := tuiAsk("Temperature (0-2): ", strconv.FormatFloat(cfg.Temperature, 'f', -1, 64))
+			t, err := strconv.ParseFloat(value, 64)
+			if err != nil || t < 0 || t > 2 {
+				status = "The temperature must be a number between 0 and 2"
+				continue
+			}
+			cfg.Temperature = t
+			msg, edited, status = tuiGenerate(ctx, cfg, diff, msg, edited)
+		case "j":
+			cfg.Emoji = !cfg.Emoji
+			msg = toggleGitmoji(cfg, msg)
+		case "d":
+			clearScreen()
+			previewDiff(&Config{Separator: cfg.Separator, Force: true}, diff)
+			tuiAsk("Press Enter to go back ", "")
+		}
+	}
+}
+
+// tuiGenerate replaces msg with a new generation, keeping the old message
+// if the model fails. A hand-edited message is replaced too, with a note.
+func tuiGenerate(ctx context.Context, cfg *Config, diff, msg string, edited bool) (string, bool, string) {
+	fresh, err := generateCommitMessage(ctx, cfg, diff)
+	if err != nil {
+		return msg, edited, "Generation failed, kept the current message: " + err.Error()
+	}
+	if edited {
+		return fresh, false, "Replaced your edited message with a new one"
+	}
+	return fresh, false, ""
+}
+
+// toggleGitmoji adds or removes the gitmoji on msg itself, following
+// cfg.Emoji, so a hand-edited message keeps its edits.
+func toggleGitmoji(cfg *Config, msg string) string {
+	for _, table := range []map[string]string{typeToGitmoji, typeToShortcode} {
+		for _, gitmoji := range table {
+			if strings.Contains(msg, gitmoji+" ") {
+				msg = strings.Replace(msg, gitmoji+" ", "", 1)
+			} else {
+				msg = strings.Replace(msg, " "+gitmoji, "", 1)
+			}
+		}
+	}
+	if cfg.Emoji {
+		msg = addGitmojiToCommitMessage(msg, cfg.CommitType, cfg.EmojiPosition, cfg.GitmojiStyle)
+	}
+	return msg
+}
+
+// tuiAsk reads a line, returning def when it is empty.
+func tuiAsk(question, def string) string {
+	if def != "" {
+		question += dim("[" + def + "] ")
+	}
+	fmt.Print(question)
+	answer, _ := readLine()
+	if answer = strings.TrimSpace(answer); answer != "" {
+		return answer
+	}
+	return def
+}
+
+// editMessage opens msg in the user's editor, as git would, and returns the
+// result without comment lines; "" means the edit was emptied.
+func editMessage(msg string) (string, error) {
+	file, err := os.CreateTemp("", "llamapusher-msg-*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(msg + "\n"); err != nil {
+		file.Close()
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+	if err := runEditor(file.Name()); err != nil {
+		return "", err
+	}
+	content, err := os.ReadFile(file.Name())
+	if err != nil {
+		return "", err
+	}
+	return stripCommentLines(string(content)), nil
+}
+
+func clearScreen() {
+	fmt.Print("\033[H\033[2J")
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestTUIInput feeds the --tui command prompt a closed or piped stdin.
+func TestTUIInput(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		wantErr       error
+		wantCommitted bool
+	}{
+		{name: "closed stdin", wantErr: ErrAborted},
+		{name: "quit", input: "q\n", wantErr: errUserAborted},
+		{name: "commit", input: "c\n", wantCommitted: true},
+		{name: "commit without a newline", input: "c", wantCommitted: true},
+		{name: "unknown command, then closed", input: "x\n", wantErr: ErrAborted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := testRepo(t)
+			stageFile(t, dir, "README.md", "readme\n")
+			git(t, "commit", "-q", "-m", "init")
+			stageFile(t, dir, "main.go", "package main\n")
+			previous := stdin
+			stdin = bufio.NewReader(strings.NewReader(tt.input))
+			t.Cleanup(func() { stdin = previous })
+
+			cfg := testConfig()
+			diff, err := getGitDiff(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = runTUI(context.Background(), cfg, diff)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("runTUI() = %v, want %v", err, tt.wantErr)
+			}
+			if committed := git(t, "log", "-1", "--format=%s") != "init"; committed != tt.wantCommitted {
+				t.Errorf("committed %v, want %v", committed, tt.wantCommitted)
+			}
+		})
+	}
+}